@@ -0,0 +1,42 @@
+package calculator
+
+import (
+	"sort"
+
+	"github.com/btsyang/mathlang/parser"
+)
+
+// Check 在计算之前检查抽象语法树中定义的所有基，不依赖任何计算请求
+// 基的合法性需要数值计算，因此由计算器而不是语法分析器检查，但诊断信息指向基的定义
+// 参数：
+//
+//	ast: 抽象语法树
+//	opts: 计算选项，决定使用浮点还是精确运算
+//
+// 返回：
+//
+//	parser.Diagnostics: 每个不合法的基一条错误，按定义顺序排列
+func Check(ast *parser.AST, opts Options) parser.Diagnostics {
+	bases := make([]*parser.Basis, 0, len(ast.Bases))
+	for _, b := range ast.Bases {
+		bases = append(bases, b)
+	}
+	sort.Slice(bases, func(i, j int) bool { return bases[i].Pos.Line < bases[j].Pos.Line })
+
+	env := newEnv(opts)
+	var diags parser.Diagnostics
+	for _, b := range bases {
+		_, err := env.checkBasis(b)
+		if err == nil {
+			continue
+		}
+		// 指向出问题的向量，整个基不合法时指向整条语句
+		be := err.(*basisError)
+		sub := ""
+		if be.Vec != nil {
+			sub = parser.VecText(be.Vec.Name)
+		}
+		diags = append(diags, b.Errorf(sub, "%s", be.Msg))
+	}
+	return diags
+}
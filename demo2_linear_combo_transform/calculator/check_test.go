@@ -0,0 +1,108 @@
+package calculator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btsyang/mathlang/parser"
+)
+
+// diagAt 是期望的诊断信息：位置和消息
+type diagAt struct {
+	line, col int
+	msg       string
+}
+
+// checkDiags 解析 src，把语法诊断与基的检查结果合并后按位置排序
+func checkDiags(t *testing.T, src string) parser.Diagnostics {
+	t.Helper()
+	ast, diags := parser.ParseAll("note.org", strings.NewReader(src))
+	diags = append(diags, Check(ast, Options{Exact: true})...)
+	diags.Sort()
+	return diags
+}
+
+func compareDiags(t *testing.T, name string, got parser.Diagnostics, want []diagAt) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: got %d diagnostics, want %d: %v", name, len(got), len(want), got)
+		return
+	}
+	for i, w := range want {
+		d := got[i]
+		if d.Pos.Line != w.line || d.Pos.Col != w.col || d.Msg != w.msg {
+			t.Errorf("%s: got %d:%d: %s\nwant %d:%d: %s", name, d.Pos.Line, d.Pos.Col, d.Msg, w.line, w.col, w.msg)
+		}
+	}
+}
+
+func TestCheckBases(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want []diagAt
+	}{
+		{
+			"valid",
+			`\vec{b}_1 = \begin{pmatrix}1\\1\end{pmatrix}
+\vec{b}_2 = \begin{pmatrix}1\\-1\end{pmatrix}
+b = \{\vec{b}_1, \vec{b}_2\}
+`,
+			nil,
+		},
+		{
+			"vector of the wrong dimension",
+			`\vec{b}_1 = \begin{pmatrix}1\\0\end{pmatrix}
+\vec{b}_2 = \begin{pmatrix}0\\1\\2\end{pmatrix}
+b = \{\vec{b}_1, \vec{b}_2\}
+`,
+			[]diagAt{{3, 18, "dimension error: vector b2 in basis b has 3 components, expected 2"}},
+		},
+		{
+			"too few vectors",
+			`\vec{b}_1 = \begin{pmatrix}1\\0\\0\end{pmatrix}
+\vec{b}_2 = \begin{pmatrix}0\\1\\0\end{pmatrix}
+b = \{\vec{b}_1, \vec{b}_2\}
+`,
+			[]diagAt{{3, 1, "dimension error: basis b of a 3-dimensional space needs 3 vectors, but has 2"}},
+		},
+		{
+			"dependent",
+			`\vec{c}_1 = \begin{pmatrix}1\\0\end{pmatrix}
+\vec{c}_2 = \begin{pmatrix}2\\0\end{pmatrix}
+c = \{\vec{c}_1, \vec{c}_2\}
+`,
+			[]diagAt{{3, 18, "basis c is linearly dependent (rank 1, 2 vectors): c2 = 2 c1"}},
+		},
+		{
+			"dependent through a linear combination",
+			`\vec{c}_1 = \begin{pmatrix}1\\0\\1\end{pmatrix}
+\vec{c}_2 = \begin{pmatrix}0\\1\\1\end{pmatrix}
+\vec{c}_3 = \vec{c}_1 - 2\vec{c}_2
+c = \{\vec{c}_1, \vec{c}_2, \vec{c}_3\}
+`,
+			[]diagAt{{4, 29, "basis c is linearly dependent (rank 2, 3 vectors): c3 = c1 - 2 c2"}},
+		},
+		{
+			// 基的错误与语法错误按行排列，不是全部排在语法错误之后
+			"mixed with parse errors",
+			`\vec{b}_1 = \begin{pmatrix}1\\0\end{pmatrix}
+\vec{b}_2 = \begin{pmatrix}2\\0\end{pmatrix}
+b = \{\vec{b}_1, \vec{b}_2\}
+\vec{x} = \begin{pmatrix}1\\q\end{pmatrix}
+\vec{c}_1 = \begin{pmatrix}1\\0\end{pmatrix}
+c = \{\vec{c}_1\}
+\vec{y} = \begin{pmatrix}1\\r\end{pmatrix}
+`,
+			[]diagAt{
+				{3, 18, "basis b is linearly dependent (rank 1, 2 vectors): b2 = 2 b1"},
+				{4, 29, `invalid component value: "q"`},
+				{6, 1, "dimension error: basis c of a 2-dimensional space needs 2 vectors, but has 1"},
+				{7, 29, `invalid component value: "r"`},
+			},
+		},
+	}
+	for _, tt := range tests {
+		compareDiags(t, tt.name, checkDiags(t, tt.src), tt.want)
+	}
+}
@@ -0,0 +1,187 @@
+package calculator
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/btsyang/mathlang/parser"
+)
+
+// evalEnv 是一次计算的求值环境
+// 它携带计算选项，并缓存由线性组合定义的向量的分量，这些分量只在计算器中求值，不写回 AST
+type evalEnv struct {
+	Options
+	vecs    map[*parser.Vec][]Scalar         // 已求值的向量分量
+	factors map[*parser.Basis]*factorization // 已分解的基矩阵，同一个基只消元一次
+	trans   map[[2]*parser.Basis]transMatrix // 已求值的过渡矩阵，键为 {原基, 新基}
+	bases   map[*parser.Basis]error          // 已检查过的基，值为检查结果
+	trace   Trace                            // 解释模式下当前计算请求已记录的步骤
+}
+
+// newEnv 创建一个空的求值环境
+func newEnv(opts Options) *evalEnv {
+	return &evalEnv{
+		Options: opts,
+		vecs:    make(map[*parser.Vec][]Scalar),
+		factors: make(map[*parser.Basis]*factorization),
+		trans:   make(map[[2]*parser.Basis]transMatrix),
+		bases:   make(map[*parser.Basis]error),
+	}
+}
+
+// record 在解释模式下记录步骤，否则什么也不做
+func (env *evalEnv) record(steps ...Step) {
+	if env.Explain {
+		env.trace = append(env.trace, steps...)
+	}
+}
+
+// comp 返回向量在当前模式下的分量
+// 参数：
+//
+//	v: 向量，可以由 pmatrix 字面量或线性组合定义
+//
+// 返回：
+//
+//	[]Scalar: 向量的分量
+//	error: 线性组合中的向量维数不一致时返回错误
+func (env *evalEnv) comp(v *parser.Vec) ([]Scalar, error) {
+	if c, ok := env.vecs[v]; ok {
+		return c, nil
+	}
+	if v.Expr == nil {
+		out := make([]Scalar, len(v.Comp))
+		for i, f := range v.Comp {
+			var r *big.Rat
+			if i < len(v.Exact) {
+				r = v.Exact[i]
+			}
+			out[i] = env.scalar(f, r)
+		}
+		env.vecs[v] = out
+		return out, nil
+	}
+
+	// 线性组合：Σ coeff·ref，引用的向量在解析时已绑定，且都先于 v 定义
+	var out []Scalar
+	for _, t := range v.Expr {
+		c, err := env.comp(t.Ref)
+		if err != nil {
+			return nil, err
+		}
+		if out == nil {
+			out = zeros(len(c), env)
+		} else if len(c) != len(out) {
+			return nil, fmt.Errorf("dimension error: in the definition of vector %s, %s has %d components, but %s has %d",
+				v.Name, t.Vec, len(c), v.Expr[0].Vec, len(out))
+		}
+		k := env.coeff(t)
+		for i, x := range c {
+			out[i] = out[i].Add(k.Mul(x))
+		}
+	}
+	env.vecs[v] = out
+	return out, nil
+}
+
+// checkBasis 检查基是否合法，结果按基缓存
+// 参数：
+//
+//	basis: 要检查的基
+//
+// 返回：
+//
+//	int: 基向量所在空间的维数，与基向量个数相同
+//	error: 基不合法时返回 *basisError
+func (env *evalEnv) checkBasis(basis *parser.Basis) (int, error) {
+	err, ok := env.bases[basis]
+	if !ok {
+		err = env.validateBasis(basis)
+		env.bases[basis] = err
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(basis.Vecs), nil
+}
+
+// basisError 是基不合法的原因，Vec 是出问题的向量，为 nil 时表示整个基
+type basisError struct {
+	Vec *parser.Vec
+	Msg string
+}
+
+func (e *basisError) Error() string { return e.Msg }
+
+// validateBasis 检查基非空、所有向量维数相同、向量个数等于空间维数，且向量线性无关
+// 线性相关时，错误信息给出秩以及每个能由前面的向量线性表示的向量的表示式，如 b3 = 2 b1 - b2
+func (env *evalEnv) validateBasis(basis *parser.Basis) error {
+	cols, err := env.basisColumns(basis)
+	if err != nil {
+		return err
+	}
+	if dim := len(cols[0]); dim != len(cols) {
+		return &basisError{Msg: fmt.Sprintf("dimension error: basis %s of a %d-dimensional space needs %d vectors, but has %d",
+			basis.Name, dim, dim, len(cols))}
+	}
+
+	// 基向量按列组成矩阵，化为简化行阶梯形后，非主元列就是能由前面的列线性表示的向量
+	R, pivots := rref(fromColumns(cols))
+	free, rels := dependencies(R, pivots, basis)
+	if len(free) == 0 {
+		return nil
+	}
+	return &basisError{Vec: basis.Vecs[free[0]], Msg: fmt.Sprintf("basis %s is linearly dependent (rank %d, %d vectors): %s",
+		basis.Name, len(pivots), len(cols), strings.Join(rels, "; "))}
+}
+
+// basisColumns 返回基向量的分量，检查基非空且所有向量的维数相同
+// 参数：
+//
+//	basis: 基
+//
+// 返回：
+//
+//	[][]Scalar: 第 j 个元素是第 j 个基向量的分量
+//	error: 基为空、向量求值失败或维数不一致时返回 *basisError
+func (env *evalEnv) basisColumns(basis *parser.Basis) ([][]Scalar, error) {
+	if len(basis.Vecs) == 0 {
+		return nil, &basisError{Msg: fmt.Sprintf("empty basis: %s", basis.Name)}
+	}
+	cols := make([][]Scalar, len(basis.Vecs))
+	for j, bv := range basis.Vecs {
+		c, err := env.comp(bv)
+		if err != nil {
+			return nil, &basisError{Vec: bv, Msg: err.Error()}
+		}
+		if j > 0 && len(c) != len(cols[0]) {
+			return nil, &basisError{Vec: bv, Msg: fmt.Sprintf("dimension error: vector %s in basis %s has %d components, expected %d",
+				bv.Name, basis.Name, len(c), len(cols[0]))}
+		}
+		cols[j] = c
+	}
+	return cols, nil
+}
+
+// factor 返回基矩阵 B（基向量为列）的分解结果，第一次使用时分解并缓存
+// 调用前需用 checkBasis 检查过基
+// 参数：
+//
+//	basis: 要分解的基
+//
+// 返回：
+//
+//	*factorization: 可重复用于求解 Bx = v 的分解结果
+//	error: 基矩阵奇异时返回错误，对检查过的基不会发生
+func (env *evalEnv) factor(basis *parser.Basis) (*factorization, error) {
+	if f, ok := env.factors[basis]; ok {
+		return f, nil
+	}
+	f, err := factor(basisMatrix(basis, env))
+	if err != nil {
+		return nil, err
+	}
+	env.factors[basis] = f
+	return f, nil
+}
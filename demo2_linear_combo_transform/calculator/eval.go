@@ -1,27 +1,93 @@
 package calculator
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/btsyang/mathlang/parser"
 )
 
-// Calculate 根据抽象语法树执行相应的计算
+// Result 是一个计算请求的结果
+type Result struct {
+	Eval  parser.EvalStmt // 对应的计算请求
+	Line  int             // 计算请求所在的源代码行号
+	Value Value           // 计算结果，标量或向量
+	Err   error           // 计算失败时的错误，此时 Value 为零值
+	Trace Trace           // 解释模式下的求解步骤，未开启或没有需要解释的步骤时为空
+	// Cascade 表示 Err 只是用到的基不合法，Check 已经在基的定义处报告过，输出诊断信息时不再重复
+	Cascade bool
+}
+
+// Calculate 根据抽象语法树执行所有计算请求
 // 参数：
 //
 //	ast: 抽象语法树，包含所有定义和计算请求
+//	opts: 计算选项，决定使用浮点还是精确运算
 //
 // 返回：
 //
-//	[]float64: 计算结果，通常是向量的分量
-//	error: 计算过程中遇到的错误
-func Calculate(ast *parser.AST) ([]float64, error) {
-	switch e := ast.Eval.(type) {
+//	[]Result: 每个计算请求对应一个结果，顺序与源文件中一致
+//	error: 第一个失败的计算请求的错误（带行号），全部成功时为 nil
+func Calculate(ast *parser.AST, opts Options) ([]Result, error) {
+	env := newEnv(opts)
+	results := make([]Result, 0, len(ast.Evals))
+	var firstErr error
+	for _, e := range ast.Evals {
+		env.trace = nil
+		value, err := calculateOne(ast, e, env)
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("line %d: %w", e.SourceLine(), err)
+		}
+		var be *basisError
+		results = append(results, Result{Eval: e, Line: e.SourceLine(), Value: value, Err: err, Trace: env.trace, Cascade: errors.As(err, &be)})
+	}
+	return results, firstErr
+}
+
+// calculateOne 执行单个计算请求
+func calculateOne(ast *parser.AST, e parser.EvalStmt, env *evalEnv) (Value, error) {
+	var vec []Scalar
+	var err error
+	switch e := e.(type) {
 	case *parser.EvalChangeBasis:
-		return evalChangeBasis(e)
+		vec, err = evalChangeBasis(e, env)
 	case *parser.EvalTransform:
-		return SolveTransform(e, ast.Transforms[e.Transform])
+		vec, err = solveTransform(e, ast.Transforms[e.Transform], env)
+	case *parser.EvalCompose:
+		vec, err = evalCompose(e, env)
+	case *parser.EvalTransformMatrix:
+		m, err := evalTransformMatrix(e, env)
+		if err != nil {
+			return Value{}, err
+		}
+		return matrixValue(m), nil
+	case *parser.EvalTransition:
+		return evalTransition(e, env)
+	case *parser.EvalInner:
+		return evalInner(e, env)
+	case *parser.EvalNorm:
+		return evalNorm(e, env)
+	case *parser.EvalAngle:
+		return evalAngle(e, env)
 	default:
-		return nil, fmt.Errorf("unknown eval type")
+		return Value{}, fmt.Errorf("unknown eval type")
+	}
+	if err != nil {
+		return Value{}, err
 	}
+	return vectorValue(vec), nil
+}
+
+// Components 求出向量在给定模式下的分量，线性组合定义的向量也按组合求值
+// 参数：
+//
+//	v: 向量
+//	opts: 计算选项，决定使用浮点还是精确运算
+//
+// 返回：
+//
+//	[]Scalar: 向量的分量
+//	error: 线性组合中的向量维数不一致时返回错误
+func Components(v *parser.Vec, opts Options) ([]Scalar, error) {
+	return newEnv(opts).comp(v)
 }
@@ -0,0 +1,80 @@
+package calculator
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/btsyang/mathlang/parser"
+)
+
+const evalNote = `\vec{b}_1 = \begin{pmatrix}1\\2\end{pmatrix}
+\vec{b}_2 = \begin{pmatrix}3\\4\end{pmatrix}
+b = \{\vec{b}_1, \vec{b}_2\}
+\vec{n}_1 = \begin{pmatrix}-1\\0\end{pmatrix}
+\vec{n}_2 = \begin{pmatrix}0\\1\end{pmatrix}
+n = \{\vec{n}_1, \vec{n}_2\}
+\vec{v} = \begin{pmatrix}1\\1\end{pmatrix}
+\vec{w} = \begin{pmatrix}0\\1\end{pmatrix}
+\vec{t} = \begin{pmatrix}1/3\\0\end{pmatrix}
+T(\vec{b}_1) = \vec{b}_1 + \vec{b}_2
+T(\vec{b}_2) = 1/3\vec{b}_1
+`
+
+// 同一个计算请求在精确模式和浮点模式下的结果
+func TestExactAndFloat(t *testing.T) {
+	tests := []struct {
+		eval  string
+		exact string
+		float string
+	}{
+		{`[\vec{v}]_b`, "(-1/2 1/2)", "(-0.5 0.5)"},
+		{`[\vec{t}]_b`, "(-2/3 1/3)", "(-0.6666666666666666 0.3333333333333333)"},
+		// 回代时 0 除以负的主元得到 -0，输出为 0
+		{`[\vec{w}]_n`, "(0 1)", "(0 1)"},
+		{`T(\vec{v})`, "(4/3 1)", "(1.3333333333333333 1)"},
+		{`P_{b \to n}`, "-1  -3\n 2   4", "-1  -3\n 2   4"},
+		{`\langle \vec{v}, \vec{t} \rangle`, "1/3", "0.3333333333333333"},
+		{`\|\vec{v}\|`, "√2", "1.4142135623730951"},
+		{`\angle(\vec{v}, \vec{w})`, "π/4", "0.7853981633974484"},
+	}
+	for _, tt := range tests {
+		ast, diags := parser.ParseAll("", strings.NewReader(evalNote+tt.eval+` \leftarrow \text{eval}`+"\n"))
+		if len(diags) > 0 {
+			t.Fatalf("%s: %v", tt.eval, diags)
+		}
+		var values [2]Value
+		for i, exact := range []bool{true, false} {
+			results, err := Calculate(ast, Options{Exact: exact})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if results[0].Err != nil {
+				t.Fatalf("%s: %v", tt.eval, results[0].Err)
+			}
+			values[i] = results[0].Value
+		}
+		if got := values[0].String(); got != tt.exact {
+			t.Errorf("%s exact = %s, want %s", tt.eval, got, tt.exact)
+		}
+		if got := values[1].String(); got != tt.float {
+			t.Errorf("%s float = %s, want %s", tt.eval, got, tt.float)
+		}
+		if values[0].Kind == ScalarValue && math.Abs(values[0].Float64()-values[1].Float64()) > 1e-12 {
+			t.Errorf("%s: exact %v and float %v differ", tt.eval, values[0].Float64(), values[1].Float64())
+		}
+	}
+}
+
+func TestFloatNegativeZero(t *testing.T) {
+	z := floatScalar(math.Copysign(0, -1))
+	if s := z.String(); s != "0" {
+		t.Errorf("String() = %q, want 0", s)
+	}
+	if math.Signbit(z.Float64()) {
+		t.Error("Float64() keeps the sign of -0")
+	}
+	if s := floatScalar(-1e-300).String(); s != "-1e-300" {
+		t.Errorf("String() = %q, want -1e-300", s)
+	}
+}
@@ -0,0 +1,240 @@
+package calculator
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/btsyang/mathlang/parser"
+)
+
+// linearMap 是由输入基向量的像确定的线性变换，系数已按当前模式求值
+// images[i] 是 from.Vecs[i] 的像，表示为 to 中向量的线性组合系数（按 to 的列序）
+type linearMap struct {
+	name   string
+	from   *parser.Basis
+	to     *parser.Basis
+	images [][]Scalar
+}
+
+// ruleMap 把 TransformRule 中的线性组合规则展开为 linearMap
+// 参数：
+//
+//	tr: 线性变换规则
+//	env: 求值环境，决定使用浮点还是精确运算
+//
+// 返回：
+//
+//	*linearMap: 展开后的线性变换
+//	error: 基为空、维数不一致、缺少某个输入向量的规则或规则中的向量不在输出基中时返回错误
+func ruleMap(tr *parser.TransformRule, env *evalEnv) (*linearMap, error) {
+	for _, basis := range []*parser.Basis{tr.FromBasis, tr.ToBasis} {
+		if _, err := env.checkBasis(basis); err != nil {
+			return nil, err
+		}
+	}
+
+	m := &linearMap{name: tr.Name, from: tr.FromBasis, to: tr.ToBasis}
+	for _, bv := range tr.FromBasis.Vecs {
+		// 检查映射是否存在
+		terms, ok := tr.Map[bv.Name]
+		if !ok {
+			return nil, fmt.Errorf("no mapping for input vector: %s", bv.Name)
+		}
+		img := zeros(len(tr.ToBasis.Vecs), env)
+		for _, term := range terms {
+			j := tr.ToBasis.IndexOf(term.Vec)
+			if j < 0 {
+				return nil, fmt.Errorf("vector %s is not in basis %s", term.Vec, tr.ToBasis.Name)
+			}
+			img[j] = img[j].Add(env.coeff(term))
+		}
+		m.images = append(m.images, img)
+	}
+	return m, nil
+}
+
+// apply 计算线性变换作用在向量上的结果
+// 参数：
+//
+//	x: 向量在 from 下的坐标
+//	env: 求值环境
+//
+// 返回：
+//
+//	[]Scalar: 像在 to 下的坐标
+func (m *linearMap) apply(x []Scalar, env *evalEnv) []Scalar {
+	out := zeros(len(m.to.Vecs), env)
+	for i, img := range m.images {
+		for j, c := range img {
+			out[j] = out[j].Add(x[i].Mul(c))
+		}
+	}
+	return out
+}
+
+// explainApply 同 apply，解释模式下把计算写成基向量的像的线性组合记录下来，如
+// T(v) = 1 T(b1) + 2 T(b2) = 1 (2 1) + 2 (-1 3) = (0 7)，像和结果都是 to 下的坐标
+func (m *linearMap) explainApply(x []Scalar, name string, env *evalEnv) []Scalar {
+	y := m.apply(x, env)
+	if env.Explain {
+		// 复合变换的名称如 S \circ T，作用在向量上时加括号
+		tname := m.name
+		if strings.Contains(tname, " ") {
+			tname = "(" + tname + ")"
+		}
+		names := make([]string, len(m.from.Vecs))
+		terms := make([]string, len(m.images))
+		for i, bv := range m.from.Vecs {
+			names[i] = fmt.Sprintf("%s(%s)", tname, bv.Name)
+			terms[i] = fmt.Sprintf("%s %s", x[i], vectorValue(m.images[i]))
+		}
+		env.record(Step{Kind: StepNote, Note: fmt.Sprintf("%s(%s) = %s = %s = %s in basis %s",
+			tname, name, formatCombo(x, names), strings.Join(terms, " + "), vectorValue(y), m.to.Name)})
+	}
+	return y
+}
+
+// compose 计算复合变换 outer ∘ inner，直接复合两者的线性组合规则
+// 如果 inner 的输出基与 outer 的输入基不同，先把每个像经由标准坐标换算到 outer 的输入基下
+// 参数：
+//
+//	outer: 后作用的变换
+//	inner: 先作用的变换
+//	env: 求值环境
+//
+// 返回：
+//
+//	*linearMap: 复合变换，输入基为 inner.from，输出基为 outer.to
+//	error: 两个基所在空间维数不同或换算时基矩阵奇异时返回错误
+func compose(outer, inner *linearMap, env *evalEnv) (*linearMap, error) {
+	m := &linearMap{name: outer.name + ` \circ ` + inner.name, from: inner.from, to: outer.to}
+	for i, img := range inner.images {
+		if inner.to != outer.from {
+			v, err := combine(inner.to, img, env)
+			if err != nil {
+				return nil, err
+			}
+			img, err = coordsIn(outer.from, v, fmt.Sprintf("%s(%s)", inner.name, inner.from.Vecs[i].Name), env)
+			if err != nil {
+				return nil, fmt.Errorf("cannot compose %s after %s: %w", outer.name, inner.name, err)
+			}
+		}
+		m.images = append(m.images, outer.apply(img, env))
+	}
+	return m, nil
+}
+
+// combine 计算线性组合 Σ coeff[i]·basis.Vecs[i] 的标准坐标
+func combine(basis *parser.Basis, coeff []Scalar, env *evalEnv) ([]Scalar, error) {
+	dim, err := env.checkBasis(basis)
+	if err != nil {
+		return nil, err
+	}
+	out := zeros(dim, env)
+	for i, bv := range basis.Vecs {
+		c, _ := env.comp(bv)
+		for j, x := range c {
+			out[j] = out[j].Add(coeff[i].Mul(x))
+		}
+	}
+	return out, nil
+}
+
+// zeros 返回长度为 n 的零向量
+func zeros(n int, env *evalEnv) []Scalar {
+	out := make([]Scalar, n)
+	for i := range out {
+		out[i] = env.zero()
+	}
+	return out
+}
+
+// evalCompose 处理复合变换计算，如 (R \circ S \circ T)(\vec{v})
+// 参数：
+//
+//	e: 复合变换计算请求，Rules 按书写顺序排列，最右边的变换最先作用
+//	env: 求值环境
+//
+// 返回：
+//
+//	[]Scalar: 计算结果，向量的像在最外层变换的输出基下的坐标
+//	error: 计算过程中遇到的错误
+func evalCompose(e *parser.EvalCompose, env *evalEnv) ([]Scalar, error) {
+	n := len(e.Rules)
+	m, err := ruleMap(e.Rules[n-1], env)
+	if err != nil {
+		return nil, err
+	}
+	for k := n - 2; k >= 0; k-- {
+		outer, err := ruleMap(e.Rules[k], env)
+		if err != nil {
+			return nil, err
+		}
+		if m, err = compose(outer, m, env); err != nil {
+			return nil, err
+		}
+	}
+
+	// 输入向量的分量是它在最内层变换的 FromBasis 下的坐标
+	vec, err := env.comp(e.Vec)
+	if err != nil {
+		return nil, err
+	}
+	if len(vec) != len(m.from.Vecs) {
+		return nil, fmt.Errorf("dimension error: transform %s takes %d coordinates in basis %s, but vector %s has %d components",
+			m.name, len(m.from.Vecs), m.from.Name, e.Vec.Name, len(vec))
+	}
+	return m.explainApply(vec, e.Vec.Name, env), nil
+}
+
+// evalTransformMatrix 构造变换在给定输入基和输出基下的矩阵 [T]_{b}^{c}
+// 矩阵逐列构造：第 j 列是 T(b_j) 在 c 下的坐标。b、c 与规则中的基相同时直接取规则中的系数，
+// 不同时经由标准坐标换算
+// 参数：
+//
+//	e: 变换矩阵计算请求
+//	env: 求值环境
+//
+// 返回：
+//
+//	[][]Scalar: 按行存放的矩阵，行数为 c 的向量个数，列数为 b 的向量个数
+//	error: 计算过程中遇到的错误
+func evalTransformMatrix(e *parser.EvalTransformMatrix, env *evalEnv) ([][]Scalar, error) {
+	m, err := ruleMap(e.Rule, env)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := env.checkBasis(e.From); err != nil {
+		return nil, err
+	}
+
+	cols := make([][]Scalar, len(e.From.Vecs))
+	for j, bv := range e.From.Vecs {
+		// b_j 在规则输入基下的坐标
+		var x []Scalar
+		if e.From == m.from {
+			x = zeros(len(m.from.Vecs), env)
+			x[j] = env.scalar(1, big.NewRat(1, 1))
+		} else {
+			c, _ := env.comp(bv)
+			if x, err = coordsIn(m.from, c, bv.Name, env); err != nil {
+				return nil, err
+			}
+		}
+		// T(b_j) 在规则输出基下的坐标，必要时换算到 c 下
+		y := m.apply(x, env)
+		if e.To != m.to {
+			v, err := combine(m.to, y, env)
+			if err != nil {
+				return nil, err
+			}
+			if y, err = coordsIn(e.To, v, fmt.Sprintf("%s(%s)", m.name, bv.Name), env); err != nil {
+				return nil, err
+			}
+		}
+		cols[j] = y
+	}
+
+	return fromColumns(cols), nil
+}
@@ -0,0 +1,170 @@
+package calculator
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/btsyang/mathlang/parser"
+)
+
+// evalInner 计算两个向量在标准坐标下的内积 ⟨u, v⟩ = Σ u_i·v_i
+// 参数：
+//
+//	e: 内积计算请求
+//	env: 求值环境
+//
+// 返回：
+//
+//	Value: 标量结果
+//	error: 两个向量维数不同时返回错误
+func evalInner(e *parser.EvalInner, env *evalEnv) (Value, error) {
+	ip, err := inner(e.U, e.V, env)
+	if err != nil {
+		return Value{}, err
+	}
+	return scalarValue(ip), nil
+}
+
+// evalNorm 计算向量的欧几里得范数 ‖v‖ = √⟨v, v⟩
+// 精确模式下结果化简为 a√n 的形式，能开尽时为有理数
+func evalNorm(e *parser.EvalNorm, env *evalEnv) (Value, error) {
+	sq, err := inner(e.Vec, e.Vec, env)
+	if err != nil {
+		return Value{}, err
+	}
+	if !env.Exact {
+		return scalarValue(floatScalar(math.Sqrt(sq.Float64()))), nil
+	}
+	coef, root := sqrtRat(sq.(ratScalar).r)
+	v := scalarValue(ratScalar{coef})
+	if root.Cmp(big.NewInt(1)) != 0 {
+		v.Root = root
+	}
+	return v, nil
+}
+
+// evalAngle 计算两个向量的夹角 θ = arccos(⟨u, v⟩ / (‖u‖‖v‖))，单位为弧度
+// 精确模式下 0、π/6、π/4、π/3、π/2 等特殊角以 π 的有理倍数给出
+func evalAngle(e *parser.EvalAngle, env *evalEnv) (Value, error) {
+	ip, err := inner(e.U, e.V, env)
+	if err != nil {
+		return Value{}, err
+	}
+	uu, _ := inner(e.U, e.U, env)
+	vv, _ := inner(e.V, e.V, env)
+	for _, p := range []struct {
+		name string
+		sq   Scalar
+	}{{e.U.Name, uu}, {e.V.Name, vv}} {
+		if p.sq.IsZero() {
+			return Value{}, fmt.Errorf("angle is undefined: vector %s is zero", p.name)
+		}
+	}
+
+	if env.Exact {
+		// cos²θ = ⟨u,v⟩² / (‖u‖²‖v‖²) 是有理数，据此识别特殊角
+		cos2 := ip.Mul(ip).Quo(uu.Mul(vv)).(ratScalar).r
+		if k, ok := specialAngle(cos2, ip.(ratScalar).r.Sign()); ok {
+			v := scalarValue(ratScalar{k})
+			v.Pi = k.Sign() != 0
+			return v, nil
+		}
+	}
+	cos := ip.Float64() / math.Sqrt(uu.Float64()*vv.Float64())
+	// 非特殊角在两种模式下都只能给出浮点近似值
+	return scalarValue(floatScalar(math.Acos(math.Max(-1, math.Min(1, cos))))), nil
+}
+
+// inner 计算两个向量的内积
+func inner(u, v *parser.Vec, env *evalEnv) (Scalar, error) {
+	a, err := env.comp(u)
+	if err != nil {
+		return nil, err
+	}
+	b, err := env.comp(v)
+	if err != nil {
+		return nil, err
+	}
+	if len(a) != len(b) {
+		return nil, fmt.Errorf("dimension error: vector %s has %d components, but vector %s has %d",
+			u.Name, len(a), v.Name, len(b))
+	}
+	sum := env.zero()
+	for i := range a {
+		sum = sum.Add(a[i].Mul(b[i]))
+	}
+	return sum, nil
+}
+
+// specialAngles 列出 cos²θ 与 [0, π/2] 内夹角（π 的倍数）的对应关系
+var specialAngles = []struct {
+	cos2  *big.Rat
+	angle *big.Rat
+}{
+	{big.NewRat(1, 1), big.NewRat(0, 1)},
+	{big.NewRat(3, 4), big.NewRat(1, 6)},
+	{big.NewRat(1, 2), big.NewRat(1, 4)},
+	{big.NewRat(1, 4), big.NewRat(1, 3)},
+	{big.NewRat(0, 1), big.NewRat(1, 2)},
+}
+
+// specialAngle 根据 cos²θ 和 cosθ 的符号识别特殊角
+// 参数：
+//
+//	cos2: cos²θ
+//	sign: cosθ 的符号，为负时夹角为钝角
+//
+// 返回：
+//
+//	*big.Rat: 夹角除以 π 的值
+//	bool: 是否为特殊角
+func specialAngle(cos2 *big.Rat, sign int) (*big.Rat, bool) {
+	for _, sa := range specialAngles {
+		if sa.cos2.Cmp(cos2) == 0 {
+			if sign < 0 {
+				return new(big.Rat).Sub(big.NewRat(1, 1), sa.angle), true
+			}
+			return new(big.Rat).Set(sa.angle), true
+		}
+	}
+	return nil, false
+}
+
+// sqrtRat 把非负有理数的平方根化简为 coef·√root 的形式，root 是不含平方因子的正整数
+// 例如 √8 = 2√2，√(1/2) = (1/2)√2
+func sqrtRat(r *big.Rat) (coef *big.Rat, root *big.Int) {
+	// √(p/q) = √(p·q) / q
+	n := new(big.Int).Mul(r.Num(), r.Denom())
+	out, in := squareFactor(n)
+	return new(big.Rat).SetFrac(out, r.Denom()), in
+}
+
+// squareFactor 把正整数分解为 out²·in，in 不含小于 10⁶ 的平方因子
+func squareFactor(n *big.Int) (out, in *big.Int) {
+	out, in = big.NewInt(1), new(big.Int).Set(n)
+	if in.Sign() == 0 {
+		return big.NewInt(0), big.NewInt(1)
+	}
+	if s := new(big.Int).Sqrt(in); new(big.Int).Mul(s, s).Cmp(in) == 0 {
+		return s, big.NewInt(1)
+	}
+	p2, rem := new(big.Int), new(big.Int)
+	for p := int64(2); p < 1_000_000; p++ {
+		bp := big.NewInt(p)
+		p2.Mul(bp, bp)
+		if p2.Cmp(in) > 0 {
+			break
+		}
+		for {
+			q := new(big.Int)
+			q.QuoRem(in, p2, rem)
+			if rem.Sign() != 0 {
+				break
+			}
+			in = q
+			out.Mul(out, bp)
+		}
+	}
+	return out, in
+}
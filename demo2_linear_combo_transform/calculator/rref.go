@@ -0,0 +1,99 @@
+package calculator
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/btsyang/mathlang/parser"
+)
+
+// rref 使用高斯-若尔当消元把矩阵化为简化行阶梯形，不修改 A
+// 参数：
+//
+//	A: 按行存放的矩阵
+//
+// 返回：
+//
+//	[][]Scalar: 简化行阶梯形 R，主元为 1，主元所在列的其他元素为 0
+//	[]int: 主元所在的列，第 i 个元素是第 i 行主元的列号，长度即矩阵的秩
+func rref(A [][]Scalar) ([][]Scalar, []int) {
+	R := make([][]Scalar, len(A))
+	for i := range A {
+		R[i] = make([]Scalar, len(A[i]))
+		copy(R[i], A[i])
+	}
+	if len(R) == 0 {
+		return R, nil
+	}
+
+	var pivots []int
+	row := 0
+	for col := 0; col < len(R[0]) && row < len(R); col++ {
+		// 选择主元行
+		maxRow := row
+		for k := row + 1; k < len(R); k++ {
+			if abs(R[k][col].Float64()) > abs(R[maxRow][col].Float64()) {
+				maxRow = k
+			}
+		}
+		// 这一列在剩余的行中全为零（浮点模式下为接近零），不是主元列
+		if R[maxRow][col].IsZero() {
+			continue
+		}
+		// 交换行
+		R[row], R[maxRow] = R[maxRow], R[row]
+
+		// 主元化为 1
+		p := R[row][col]
+		for j := col; j < len(R[row]); j++ {
+			R[row][j] = R[row][j].Quo(p)
+		}
+		// 消去其他行在主元列上的元素
+		for k := range R {
+			if k == row || R[k][col].IsZero() {
+				continue
+			}
+			f := R[k][col]
+			for j := col; j < len(R[k]); j++ {
+				R[k][j] = R[k][j].Sub(f.Mul(R[row][j]))
+			}
+		}
+		pivots = append(pivots, col)
+		row++
+	}
+	return R, pivots
+}
+
+// dependencies 根据基向量（及可能附加的右侧列）组成的矩阵的简化行阶梯形，
+// 写出每个不是主元列的基向量由前面的主元列线性表示的关系
+// 参数：
+//
+//	R: 简化行阶梯形，前 len(basis.Vecs) 列对应基向量
+//	pivots: 主元所在的列
+//	basis: 基，用于给列命名
+//
+// 返回：
+//
+//	[]int: 不是主元列的基向量下标，即自由变量
+//	[]string: 与自由变量一一对应的关系式，如 b3 = 2 b1 - b2
+func dependencies(R [][]Scalar, pivots []int, basis *parser.Basis) ([]int, []string) {
+	var free []int
+	var rels []string
+	for f, bv := range basis.Vecs {
+		if slices.Contains(pivots, f) {
+			continue
+		}
+		// 第 i 行的主元在 pivots[i] 列，R[i][f] 是该主元列的系数；主元列在 f 之后的行系数为零
+		var coeff []Scalar
+		var names []string
+		for i, p := range pivots {
+			if p < f {
+				coeff = append(coeff, R[i][f])
+				names = append(names, basis.Vecs[p].Name)
+			}
+		}
+		free = append(free, f)
+		rels = append(rels, fmt.Sprintf("%s = %s", bv.Name, formatCombo(coeff, names)))
+	}
+	return free, rels
+}
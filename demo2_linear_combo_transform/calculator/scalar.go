@@ -0,0 +1,97 @@
+package calculator
+
+import (
+	"math/big"
+	"strconv"
+
+	"github.com/btsyang/mathlang/parser"
+)
+
+// Options 控制一次计算的方式
+type Options struct {
+	Exact   bool // 为 true 时使用 big.Rat 做精确的有理数运算，否则使用 float64
+	Explain bool // 为 true 时记录求解过程中的每一步，见 Result.Trace
+}
+
+// Scalar 是计算器中的标量，浮点模式下是 float64，精确模式下是 big.Rat
+// 同一次计算中的所有标量来自同一种实现，不同实现之间不能混合运算
+type Scalar interface {
+	Add(Scalar) Scalar
+	Sub(Scalar) Scalar
+	Mul(Scalar) Scalar
+	Quo(Scalar) Scalar
+	Neg() Scalar
+	IsZero() bool     // 浮点模式下以 1e-10 为阈值，精确模式下严格为零
+	Float64() float64 // 近似的浮点值，用于选主元和输出
+	String() string   // 浮点模式下为最短十进制表示，精确模式下为约分后的分数
+}
+
+// floatScalar 是基于 float64 的标量
+type floatScalar float64
+
+func (x floatScalar) Add(y Scalar) Scalar { return x + y.(floatScalar) }
+func (x floatScalar) Sub(y Scalar) Scalar { return x - y.(floatScalar) }
+func (x floatScalar) Mul(y Scalar) Scalar { return x * y.(floatScalar) }
+func (x floatScalar) Quo(y Scalar) Scalar { return x / y.(floatScalar) }
+func (x floatScalar) Neg() Scalar         { return -x }
+func (x floatScalar) IsZero() bool        { return abs(float64(x)) < 1e-10 }
+func (x floatScalar) Float64() float64    { return float64(x.normal()) }
+func (x floatScalar) String() string      { return strconv.FormatFloat(float64(x.normal()), 'g', -1, 64) }
+
+// normal 把 -0 换成 0，如 0 乘以负数得到的 -0，输出时不带负号
+func (x floatScalar) normal() floatScalar {
+	if x == 0 {
+		return 0
+	}
+	return x
+}
+
+// ratScalar 是基于 big.Rat 的精确标量，运算总是返回新值，不修改操作数
+type ratScalar struct {
+	r *big.Rat
+}
+
+func (x ratScalar) Add(y Scalar) Scalar { return ratScalar{new(big.Rat).Add(x.r, y.(ratScalar).r)} }
+func (x ratScalar) Sub(y Scalar) Scalar { return ratScalar{new(big.Rat).Sub(x.r, y.(ratScalar).r)} }
+func (x ratScalar) Mul(y Scalar) Scalar { return ratScalar{new(big.Rat).Mul(x.r, y.(ratScalar).r)} }
+func (x ratScalar) Quo(y Scalar) Scalar { return ratScalar{new(big.Rat).Quo(x.r, y.(ratScalar).r)} }
+func (x ratScalar) Neg() Scalar         { return ratScalar{new(big.Rat).Neg(x.r)} }
+func (x ratScalar) IsZero() bool        { return x.r.Sign() == 0 }
+func (x ratScalar) String() string      { return x.r.RatString() }
+
+func (x ratScalar) Float64() float64 {
+	f, _ := x.r.Float64()
+	return f
+}
+
+// zero 返回当前模式下的零
+func (o Options) zero() Scalar {
+	if o.Exact {
+		return ratScalar{new(big.Rat)}
+	}
+	return floatScalar(0)
+}
+
+// scalar 根据当前模式选择字面量的浮点值或精确值
+// 参数：
+//
+//	f: 浮点值
+//	r: 精确值，可能为 nil（此时由 f 精确转换而来）
+//
+// 返回：
+//
+//	Scalar: 当前模式下的标量
+func (o Options) scalar(f float64, r *big.Rat) Scalar {
+	if !o.Exact {
+		return floatScalar(f)
+	}
+	if r == nil {
+		r = new(big.Rat).SetFloat64(f)
+	}
+	return ratScalar{r}
+}
+
+// coeff 返回线性组合中一项的系数在当前模式下的值
+func (o Options) coeff(t parser.LinearTerm) Scalar {
+	return o.scalar(t.Coeff, t.Exact)
+}
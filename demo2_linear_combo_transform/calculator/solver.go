@@ -1,21 +1,39 @@
 package calculator
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/btsyang/mathlang/parser"
 )
 
-// printMatPretty 打印矩阵的漂亮格式
+// printMatPretty 把矩阵按列右对齐打印到 w，每行一行文本
 // 参数：
 //
+//	w: 输出目标
 //	A: 要打印的矩阵
-func printMatPretty(A [][]float64) {
+func printMatPretty(w io.Writer, A [][]Scalar) {
+	width := make([]int, 0)
 	for i := range A {
 		for j := range A[i] {
-			fmt.Printf("%8.3f ", A[i][j])
+			if j >= len(width) {
+				width = append(width, 0)
+			}
+			width[j] = max(width[j], utf8.RuneCountInString(A[i][j].String()))
 		}
-		fmt.Println()
+	}
+	for i := range A {
+		for j := range A[i] {
+			if j > 0 {
+				fmt.Fprint(w, "  ")
+			}
+			fmt.Fprintf(w, "%*s", width[j], A[i][j])
+		}
+		fmt.Fprintln(w)
 	}
 }
 
@@ -23,88 +41,333 @@ func printMatPretty(A [][]float64) {
 // 参数：
 //
 //	e: 基变换计算请求
+//	env: 求值环境，决定使用浮点还是精确运算
 //
 // 返回：
 //
-//	[]float64: 计算结果，向量在新基下的坐标
+//	[]Scalar: 计算结果，向量在新基下的坐标
 //	error: 计算过程中遇到的错误
-func evalChangeBasis(e *parser.EvalChangeBasis) ([]float64, error) {
-	basis := e.Basis
-	vec := e.Vec.Comp
+func evalChangeBasis(e *parser.EvalChangeBasis, env *evalEnv) ([]Scalar, error) {
+	vec, err := env.comp(e.Vec)
+	if err != nil {
+		return nil, err
+	}
+	x, err := coordsIn(e.Basis, vec, e.Vec.Name, env)
+	if _, ok := err.(*basisError); ok {
+		// 基不合法时坐标没有唯一解，解释原因：基的秩、向量是否在张成空间中，以及全部解
+		if msg, ok := explainCoords(e.Basis, vec, e.Vec.Name, env); ok {
+			return nil, errors.New(msg)
+		}
+	}
+	return x, err
+}
 
-	dim := len(basis.Vecs)
-	if dim == 0 {
-		return nil, fmt.Errorf("empty basis: %s", basis.Name)
+// explainCoords 在基不合法时解释方程组 Bx = v 的解
+// 对增广矩阵 [B | v] 做高斯-若尔当消元，给出 B 的秩、线性相关关系、v 是否在基的张成空间中，
+// 在张成空间中时以参数形式给出全部解，如 [v]_b = (1 1 0) + t1 (-2 1 1)
+// 参数：
+//
+//	basis: 基，向量个数可以与空间维数不同，向量也可以线性相关
+//	vec: 向量在标准坐标下的分量
+//	name: 向量名称
+//	env: 求值环境
+//
+// 返回：
+//
+//	string: 解释
+//	bool: 基为空、维数不一致等无法列出方程组的情况返回 false
+func explainCoords(basis *parser.Basis, vec []Scalar, name string, env *evalEnv) (string, bool) {
+	cols, err := env.basisColumns(basis)
+	if err != nil || len(cols[0]) != len(vec) {
+		return "", false
+	}
+	n := len(cols)
+	R, pivots := rref(fromColumns(append(cols, vec)))
+	free, rels := dependencies(R, pivots, basis)
+	rank := len(pivots)
+	consistent := true
+	if rank > 0 && pivots[rank-1] == n {
+		// 增广列是主元列，说明出现了 0 = 1 的方程
+		rank--
+		consistent = false
+	}
+
+	parts := []string{fmt.Sprintf("basis %s has rank %d with %d vectors in a %d-dimensional space",
+		basis.Name, rank, n, len(vec))}
+	if len(rels) > 0 {
+		parts[0] += ", " + strings.Join(rels, ", ")
+	}
+	if !consistent {
+		parts = append(parts, fmt.Sprintf("%s is not in the span of %s, so [%s]_%s has no solution", name, basis.Name, name, basis.Name))
+		return strings.Join(parts, "; "), true
+	}
+
+	// 特解：自由变量取 0，主元变量取增广列的值
+	xp := zeros(n, env)
+	for i, p := range pivots {
+		xp[p] = R[i][n]
+	}
+	sol := vectorValue(xp).String()
+	// 齐次解：每个自由变量取 1，其余自由变量取 0
+	params := make([]string, len(free))
+	for k, f := range free {
+		xh := zeros(n, env)
+		xh[f] = env.scalar(1, big.NewRat(1, 1))
+		for i, p := range pivots {
+			xh[p] = R[i][f].Neg()
+		}
+		params[k] = fmt.Sprintf("t%d", k+1)
+		sol += fmt.Sprintf(" + %s %s", params[k], vectorValue(xh))
+	}
+	msg := fmt.Sprintf("%s is in the span of %s, [%s]_%s = %s", name, basis.Name, name, basis.Name, sol)
+	if len(params) > 0 {
+		msg += " for any " + strings.Join(params, ", ")
 	}
+	parts = append(parts, msg)
+	return strings.Join(parts, "; "), true
+}
 
-	// 拼旧基矩阵 B (dim x dim)
-	B := make([][]float64, dim)
+// evalTransition 处理过渡矩阵计算 P_{b \to c}
+// 参数：
+//
+//	e: 过渡矩阵计算请求
+//	env: 求值环境
+//
+// 返回：
+//
+//	Value: Vec 为空时是过渡矩阵，否则是 Vec 在 c 下的坐标
+//	error: 计算过程中遇到的错误
+func evalTransition(e *parser.EvalTransition, env *evalEnv) (Value, error) {
+	P, err := transition(e.From, e.To, env)
+	if err != nil {
+		return Value{}, err
+	}
+	if e.Vec == nil {
+		return matrixValue(P), nil
+	}
+
+	// 向量的分量是它在 b 下的坐标，P 乘以它得到在 c 下的坐标
+	x, err := env.comp(e.Vec)
+	if err != nil {
+		return Value{}, err
+	}
+	if len(x) != len(e.From.Vecs) {
+		return Value{}, fmt.Errorf("dimension error: %s takes %d coordinates in basis %s, but vector %s has %d components",
+			transitionName(e.From, e.To), len(e.From.Vecs), e.From.Name, e.Vec.Name, len(x))
+	}
+	y := zeros(len(P), env)
+	for i := range P {
+		for j, xj := range x {
+			y[i] = y[i].Add(P[i][j].Mul(xj))
+		}
+	}
+	if env.Explain {
+		// P 的第 j 列是 b 的第 j 个向量在 c 下的坐标，P x 是这些列的线性组合
+		names := make([]string, len(x))
+		terms := make([]string, len(x))
+		for j, bv := range e.From.Vecs {
+			col := make([]Scalar, len(P))
+			for i := range P {
+				col[i] = P[i][j]
+			}
+			names[j] = fmt.Sprintf("[%s]_%s", bv.Name, e.To.Name)
+			terms[j] = fmt.Sprintf("%s %s", x[j], vectorValue(col))
+		}
+		env.record(Step{Kind: StepNote, Note: fmt.Sprintf("P(%s) = %s = %s = %s in basis %s",
+			e.Vec.Name, formatCombo(x, names), strings.Join(terms, " + "), vectorValue(y), e.To.Name)})
+	}
+	return vectorValue(y), nil
+}
+
+// transMatrix 是缓存的过渡矩阵，steps 是解释模式下求解它的步骤，再次使用时重新记录
+type transMatrix struct {
+	P     [][]Scalar
+	steps Trace
+}
+
+// transition 求从基 from 到基 to 的过渡矩阵，结果按基对缓存，解释模式下再次使用时重新记录求解步骤
+// 第 j 列是 from 的第 j 个向量在 to 下的坐标，所有列共用 to 的同一次分解
+// 参数：
+//
+//	from: 原基
+//	to: 新基
+//	env: 求值环境
+//
+// 返回：
+//
+//	[][]Scalar: 按行存放的过渡矩阵
+//	error: 维数不一致或新基矩阵奇异时返回错误
+func transition(from, to *parser.Basis, env *evalEnv) ([][]Scalar, error) {
+	key := [2]*parser.Basis{from, to}
+	if t, ok := env.trans[key]; ok {
+		env.record(t.steps...)
+		return t.P, nil
+	}
+	if _, err := env.checkBasis(from); err != nil {
+		return nil, err
+	}
+	mark := len(env.trace)
+	cols := make([][]Scalar, len(from.Vecs))
+	for j, bv := range from.Vecs {
+		c, _ := env.comp(bv)
+		x, err := coordsIn(to, c, bv.Name, env)
+		if err != nil {
+			return nil, err
+		}
+		cols[j] = x
+	}
+	P := fromColumns(cols)
+	env.trans[key] = transMatrix{P: P, steps: append(Trace(nil), env.trace[mark:]...)}
+	return P, nil
+}
+
+// transitionName 返回过渡矩阵的名称，用于错误信息
+func transitionName(from, to *parser.Basis) string {
+	return fmt.Sprintf("P_{%s \\to %s}", from.Name, to.Name)
+}
+
+// fromColumns 把按列存放的矩阵转为按行存放
+func fromColumns(cols [][]Scalar) [][]Scalar {
+	rows := make([][]Scalar, len(cols[0]))
+	for i := range rows {
+		rows[i] = make([]Scalar, len(cols))
+		for j := range cols {
+			rows[i][j] = cols[j][i]
+		}
+	}
+	return rows
+}
+
+// coordsIn 求向量在给定基下的坐标，即求解 Bx = v
+// 参数：
+//
+//	basis: 目标基
+//	vec: 向量在标准坐标下的分量
+//	name: 向量名称，用于错误信息
+//	env: 求值环境，决定使用浮点还是精确运算
+//
+// 返回：
+//
+//	[]Scalar: 向量在 basis 下的坐标
+//	error: 维数不一致或基不合法时返回错误
+func coordsIn(basis *parser.Basis, vec []Scalar, name string, env *evalEnv) ([]Scalar, error) {
+	space, err := env.checkBasis(basis)
+	if err != nil {
+		return nil, err
+	}
+	if len(vec) != space {
+		return nil, fmt.Errorf("dimension error: vector %s has %d components, but vectors in basis %s have %d",
+			name, len(vec), basis.Name, space)
+	}
+	f, err := env.factor(basis)
+	if err != nil {
+		return nil, err
+	}
+	if env.Explain {
+		env.record(traceSolve(basisMatrix(basis, env), vec, fmt.Sprintf("solve [%s | %s]", basis.Name, name), env)...)
+	}
+	return f.solve(vec), nil
+}
+
+// basisMatrix 把基向量按列拼成矩阵 B，调用前需用 checkBasis 检查过基
+func basisMatrix(basis *parser.Basis, env *evalEnv) [][]Scalar {
+	dim := len(basis.Vecs)
+	B := make([][]Scalar, dim)
 	for i := 0; i < dim; i++ {
-		B[i] = make([]float64, dim)
-		for j := 0; j < dim; j++ {
-			B[i][j] = basis.Vecs[j].Comp[i]
+		B[i] = make([]Scalar, dim)
+	}
+	for j := 0; j < dim; j++ {
+		// checkBasis 已经求值过所有基向量，这里直接取缓存
+		col, _ := env.comp(basis.Vecs[j])
+		for i, x := range col {
+			B[i][j] = x
 		}
 	}
-	return solve(B, vec)
+	return B
+}
 
+// factorization 是方阵经带部分主元的高斯消元得到的 LU 分解 PB = LU，
+// 分解一次之后可以对任意多个右侧向量求解，不必重复消元
+type factorization struct {
+	lu   [][]Scalar // 上三角部分（含对角线）为 U，严格下三角部分为消元乘数 L
+	perm []int      // perm[i] 是分解后第 i 行在 B 中的行号
 }
 
-// solve 使用高斯消元法求解线性方程组 Bx = v
+// factor 使用高斯消元法分解系数矩阵
 // 参数：
 //
-//	B: 系数矩阵
-//	v: 右侧向量
+//	B: 系数矩阵（方阵）
 //
 // 返回：
 //
-//	[]float64: 解向量 x
-//	error: 求解过程中遇到的错误，如矩阵奇异
-func solve(B [][]float64, v []float64) ([]float64, error) {
-	n := len(v)
-	aug := make([][]float64, n)
+//	*factorization: 分解结果
+//	error: 矩阵奇异时返回错误
+func factor(B [][]Scalar) (*factorization, error) {
+	n := len(B)
+	lu := make([][]Scalar, n)
+	perm := make([]int, n)
 	for i := 0; i < n; i++ {
-		aug[i] = make([]float64, n+1)
-		copy(aug[i][:n], B[i])
-		aug[i][n] = v[i]
+		lu[i] = make([]Scalar, n)
+		copy(lu[i], B[i])
+		perm[i] = i
 	}
 	// 前向消元
 	for i := 0; i < n; i++ {
 		// 选择主元行
 		maxRow := i
 		for k := i + 1; k < n; k++ {
-			if abs(aug[k][i]) > abs(aug[maxRow][i]) {
+			if abs(lu[k][i].Float64()) > abs(lu[maxRow][i].Float64()) {
 				maxRow = k
 			}
 		}
 		// 交换行
-		aug[i], aug[maxRow] = aug[maxRow], aug[i]
+		lu[i], lu[maxRow] = lu[maxRow], lu[i]
+		perm[i], perm[maxRow] = perm[maxRow], perm[i]
 
-		// 检查主元是否为零（或接近零），如果是，则矩阵奇异
-		if abs(aug[i][i]) < 1e-10 {
+		// 检查主元是否为零（浮点模式下为接近零），如果是，则矩阵奇异
+		if lu[i][i].IsZero() {
 			return nil, fmt.Errorf("singular matrix: cannot solve linear system")
 		}
 
-		// 消元
+		// 消元，乘数保存在被消去的位置上
 		for k := i + 1; k < n; k++ {
-			f := aug[k][i] / aug[i][i]
-			for j := i; j < n+1; j++ {
-				aug[k][j] -= f * aug[i][j]
+			f := lu[k][i].Quo(lu[i][i])
+			lu[k][i] = f
+			for j := i + 1; j < n; j++ {
+				lu[k][j] = lu[k][j].Sub(f.Mul(lu[i][j]))
 			}
 		}
 	}
+	return &factorization{lu: lu, perm: perm}, nil
+}
+
+// solve 利用分解结果求解线性方程组 Bx = v
+// 参数：
+//
+//	v: 右侧向量
+//
+// 返回：
+//
+//	[]Scalar: 解向量 x
+func (f *factorization) solve(v []Scalar) []Scalar {
+	n := len(f.lu)
+	// 按消元时的行交换重排右侧向量，再用 L 前代
+	y := make([]Scalar, n)
+	for i := 0; i < n; i++ {
+		y[i] = v[f.perm[i]]
+		for k := 0; k < i; k++ {
+			y[i] = y[i].Sub(f.lu[i][k].Mul(y[k]))
+		}
+	}
 	// 回代求解
-	x := make([]float64, n)
+	x := make([]Scalar, n)
 	for i := n - 1; i >= 0; i-- {
-		// 检查主元是否为零（或接近零）
-		if abs(aug[i][i]) < 1e-10 {
-			return nil, fmt.Errorf("singular matrix: cannot solve linear system")
-		}
-		x[i] = aug[i][n] / aug[i][i]
-		for k := i - 1; k >= 0; k-- {
-			aug[k][n] -= aug[k][i] * x[i]
+		for k := i + 1; k < n; k++ {
+			y[i] = y[i].Sub(f.lu[i][k].Mul(x[k]))
 		}
+		x[i] = y[i].Quo(f.lu[i][i])
 	}
-	return x, nil
+	return x
 }
 
 // abs 计算浮点数的绝对值
@@ -127,25 +390,30 @@ func abs(x float64) float64 {
 //
 //	eval: 线性变换计算请求
 //	tr: 线性变换规则
+//	opts: 计算选项，决定使用浮点还是精确运算
 //
 // 返回：
 //
-//	[]float64: 计算结果，向量经过线性变换后的坐标
+//	[]Scalar: 计算结果，向量经过线性变换后的坐标
 //	error: 计算过程中遇到的错误
-func SolveTransform(eval *parser.EvalTransform, tr *parser.TransformRule) ([]float64, error) {
-
-	dim := len(tr.Map)
-	result := make([]float64, dim)
-	for i, bv := range tr.FromBasis.Vecs {
-		// 检查映射是否存在
-		if _, ok := tr.Map[bv.Name]; !ok {
-			return nil, fmt.Errorf("no mapping for input vector: %s", bv.Name)
-		}
-		vi := eval.Vec.Comp[i]
-		for _, term := range tr.Map[bv.Name] {
-			j := tr.ToBasis.IndexOf(term.Vec)
-			result[j] += vi * term.Coeff
-		}
+func SolveTransform(eval *parser.EvalTransform, tr *parser.TransformRule, opts Options) ([]Scalar, error) {
+	return solveTransform(eval, tr, newEnv(opts))
+}
+
+// solveTransform 是 SolveTransform 在给定求值环境下的实现
+func solveTransform(eval *parser.EvalTransform, tr *parser.TransformRule, env *evalEnv) ([]Scalar, error) {
+	vec, err := env.comp(eval.Vec)
+	if err != nil {
+		return nil, err
+	}
+	// 输入向量的分量是它在 FromBasis 下的坐标，个数必须等于 FromBasis 的向量个数
+	if len(vec) != len(tr.FromBasis.Vecs) {
+		return nil, fmt.Errorf("dimension error: transform %s takes %d coordinates in basis %s, but vector %s has %d components",
+			tr.Name, len(tr.FromBasis.Vecs), tr.FromBasis.Name, eval.Vec.Name, len(vec))
+	}
+	m, err := ruleMap(tr, env)
+	if err != nil {
+		return nil, err
 	}
-	return result, nil
+	return m.explainApply(vec, eval.Vec.Name, env), nil
 }
@@ -0,0 +1,255 @@
+package calculator
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// StepKind 区分消元过程中每一步的种类
+type StepKind int
+
+const (
+	StepStart     StepKind = iota // 初始增广矩阵
+	StepPivot                     // 选主元，不改变矩阵
+	StepSwap                      // 交换第 I 行和第 J 行
+	StepEliminate                 // 第 I 行减去第 J 行的 Factor 倍
+	StepScale                     // 第 I 行乘以 Factor，使主元为 1
+	StepNote                      // 文字说明，如回代开始、线性组合的展开
+)
+
+// Step 是解释模式下记录的一步计算
+type Step struct {
+	Kind   StepKind
+	I, J   int        // 行号，从 0 开始
+	Col    int        // StepPivot 的主元列
+	Factor Scalar     // StepEliminate、StepScale 的系数
+	Note   string     // StepStart、StepNote 的说明
+	Matrix [][]Scalar // 这一步之后的增广矩阵，StepPivot、StepNote 为 nil
+}
+
+// Text 返回这一步的单行文字说明，如 pivot in column 1: R2 (2)、R1 <-> R2、R2 - 2 R1
+func (s Step) Text() string {
+	switch s.Kind {
+	case StepPivot:
+		return fmt.Sprintf("pivot in column %d: R%d (%s)", s.Col+1, s.I+1, s.Factor)
+	case StepSwap:
+		return fmt.Sprintf("R%d <-> R%d", s.I+1, s.J+1)
+	case StepEliminate:
+		return fmt.Sprintf("R%d %sR%d", s.I+1, signed(s.Factor.Neg(), func(c Scalar) string { return c.String() + " " }), s.J+1)
+	case StepScale:
+		return fmt.Sprintf("R%d * %s", s.I+1, s.Factor)
+	default:
+		return s.Note
+	}
+}
+
+// Trace 是一个计算请求的全部步骤
+type Trace []Step
+
+// traceSolve 对增广矩阵 [B | v] 做高斯-若尔当消元并记录每一步：
+// 选主元、交换行、消元，以及回代（主元化为 1 并消去主元上方的元素）
+// 计算结果仍由 factorization 给出，这里只用于向学生展示过程
+// 参数：
+//
+//	B: 系数矩阵（方阵，已检查过非奇异）
+//	v: 右侧向量
+//	title: 初始矩阵的说明，如 [b | v]
+//	env: 求值环境
+//
+// 返回：
+//
+//	Trace: 消元步骤
+func traceSolve(B [][]Scalar, v []Scalar, title string, env *evalEnv) Trace {
+	n := len(v)
+	aug := make([][]Scalar, n)
+	for i := 0; i < n; i++ {
+		aug[i] = make([]Scalar, n+1)
+		copy(aug[i][:n], B[i])
+		aug[i][n] = v[i]
+	}
+	tr := Trace{{Kind: StepStart, Note: title, Matrix: snapshot(aug)}}
+
+	// 前向消元
+	for i := 0; i < n; i++ {
+		// 选择主元行
+		maxRow := i
+		for k := i + 1; k < n; k++ {
+			if abs(aug[k][i].Float64()) > abs(aug[maxRow][i].Float64()) {
+				maxRow = k
+			}
+		}
+		tr = append(tr, Step{Kind: StepPivot, I: maxRow, Col: i, Factor: aug[maxRow][i]})
+		if maxRow != i {
+			aug[i], aug[maxRow] = aug[maxRow], aug[i]
+			tr = append(tr, Step{Kind: StepSwap, I: i, J: maxRow, Matrix: snapshot(aug)})
+		}
+		if aug[i][i].IsZero() {
+			return tr
+		}
+		for k := i + 1; k < n; k++ {
+			if aug[k][i].IsZero() {
+				continue
+			}
+			f := aug[k][i].Quo(aug[i][i])
+			for j := i; j < n+1; j++ {
+				aug[k][j] = aug[k][j].Sub(f.Mul(aug[i][j]))
+			}
+			tr = append(tr, Step{Kind: StepEliminate, I: k, J: i, Factor: f, Matrix: snapshot(aug)})
+		}
+	}
+
+	// 回代：从最后一行开始，主元化为 1，再消去上方各行在主元列上的元素
+	tr = append(tr, Step{Kind: StepNote, Note: "back substitution"})
+	one := env.scalar(1, big.NewRat(1, 1))
+	for i := n - 1; i >= 0; i-- {
+		if p := aug[i][i]; !p.Sub(one).IsZero() {
+			k := one.Quo(p)
+			for j := i; j < n+1; j++ {
+				aug[i][j] = aug[i][j].Mul(k)
+			}
+			tr = append(tr, Step{Kind: StepScale, I: i, Factor: k, Matrix: snapshot(aug)})
+		}
+		for k := i - 1; k >= 0; k-- {
+			if aug[k][i].IsZero() {
+				continue
+			}
+			f := aug[k][i]
+			for j := i; j < n+1; j++ {
+				aug[k][j] = aug[k][j].Sub(f.Mul(aug[i][j]))
+			}
+			tr = append(tr, Step{Kind: StepEliminate, I: k, J: i, Factor: f, Matrix: snapshot(aug)})
+		}
+	}
+	return tr
+}
+
+// snapshot 复制矩阵，使记录下来的步骤不受之后的行变换影响
+func snapshot(A [][]Scalar) [][]Scalar {
+	out := make([][]Scalar, len(A))
+	for i := range A {
+		out[i] = append([]Scalar(nil), A[i]...)
+	}
+	return out
+}
+
+// String 返回步骤的文本形式：每一步一行说明，改变矩阵的步骤后跟缩进的矩阵
+//
+//	[b | v]
+//	    1  3  1
+//	    2  4  1
+//	pivot in column 1: R2 (2)
+//	R1 <-> R2
+//	    2  4  1
+//	    1  3  1
+func (t Trace) String() string {
+	var sb strings.Builder
+	for _, s := range t {
+		sb.WriteString(s.Text() + "\n")
+		if s.Matrix != nil {
+			var m strings.Builder
+			printMatPretty(&m, s.Matrix)
+			for _, line := range strings.SplitAfter(strings.TrimSuffix(m.String(), "\n"), "\n") {
+				sb.WriteString("    " + line)
+			}
+			sb.WriteString("\n")
+		}
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// LaTeX 返回步骤的 LaTeX 形式：aligned 环境中每行一个 \xrightarrow{行变换} 和变换后的增广矩阵
+// 每次求解从一行说明和它的初始增广矩阵开始，文字说明单独成行，选主元不改变矩阵，不出现在 LaTeX 中
+//
+//	\begin{aligned}
+//	&\text{solve [b | v]} \\
+//	\left(\begin{array}{cc|c}1 & 3 & 1\\2 & 4 & 1\end{array}\right) &\xrightarrow{R_1 \leftrightarrow R_2} ... \\
+//	&\xrightarrow{R_2 - \frac{1}{2}R_1} ... \\
+//	&\text{back substitution}
+//	\end{aligned}
+func (t Trace) LaTeX() string {
+	var rows []string
+	start := "" // 还没有写出的初始增广矩阵
+	flush := func() {
+		if start != "" {
+			rows = append(rows, start)
+			start = ""
+		}
+	}
+	for _, s := range t {
+		var op string
+		switch s.Kind {
+		case StepStart:
+			flush()
+			rows = append(rows, `&`+textLaTeX(s.Note))
+			start = augLaTeX(s.Matrix)
+			continue
+		case StepNote:
+			flush()
+			rows = append(rows, `&`+textLaTeX(s.Note))
+			continue
+		case StepSwap:
+			op = fmt.Sprintf(`R_%d \leftrightarrow R_%d`, s.I+1, s.J+1)
+		case StepEliminate:
+			op = fmt.Sprintf(`R_%d %sR_%d`, s.I+1, signed(s.Factor.Neg(), scalarLaTeX), s.J+1)
+		case StepScale:
+			op = fmt.Sprintf(`%sR_%d`, scalarLaTeX(s.Factor), s.I+1)
+		default:
+			continue
+		}
+		// 求解的第一次行变换与初始矩阵写在同一行
+		if start != "" {
+			start += " "
+		}
+		rows = append(rows, fmt.Sprintf(`%s&\xrightarrow{%s} %s`, start, op, augLaTeX(s.Matrix)))
+		start = ""
+	}
+	flush()
+	return "\\begin{aligned}\n" + strings.Join(rows, " \\\\\n") + "\n\\end{aligned}"
+}
+
+// textLaTeX 把文字说明写成 \text{...}，转义 LaTeX 的特殊字符，复合变换中的 \circ 写成数学符号
+func textLaTeX(s string) string {
+	return `\text{` + latexEscaper.Replace(s) + `}`
+}
+
+var latexEscaper = strings.NewReplacer(
+	`\circ`, `$\circ$`,
+	`\`, `\textbackslash{}`,
+	`{`, `\{`,
+	`}`, `\}`,
+	`_`, `\_`,
+	`^`, `\^{}`,
+	`&`, `\&`,
+	`%`, `\%`,
+	`#`, `\#`,
+	`$`, `\$`,
+)
+
+// signed 把系数写成 "+ a" 或 "- a" 的形式，a 由 format 给出，系数为 ±1 时省略 a
+func signed(c Scalar, format func(Scalar) string) string {
+	sign := "+ "
+	if c.Float64() < 0 {
+		sign, c = "- ", c.Neg()
+	}
+	if c.String() == "1" {
+		return sign
+	}
+	return sign + format(c)
+}
+
+// augLaTeX 返回增广矩阵的 LaTeX 形式，最后一列前加竖线
+func augLaTeX(A [][]Scalar) string {
+	lines := make([]string, len(A))
+	cols := 0
+	for i, row := range A {
+		cells := make([]string, len(row))
+		for j, x := range row {
+			cells[j] = scalarLaTeX(x)
+		}
+		lines[i] = strings.Join(cells, " & ")
+		cols = len(row)
+	}
+	spec := strings.Repeat("c", max(cols-1, 0)) + "|c"
+	return `\left(\begin{array}{` + spec + `}` + strings.Join(lines, `\\`) + `\end{array}\right)`
+}
@@ -0,0 +1,140 @@
+package calculator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btsyang/mathlang/parser"
+)
+
+const traceBases = `\vec{b}_1 = \begin{pmatrix}1\\2\end{pmatrix}
+\vec{b}_2 = \begin{pmatrix}3\\4\end{pmatrix}
+b = \{\vec{b}_1, \vec{b}_2\}
+\vec{c}_1 = \begin{pmatrix}1\\0\end{pmatrix}
+\vec{c}_2 = \begin{pmatrix}1\\1\end{pmatrix}
+c = \{\vec{c}_1, \vec{c}_2\}
+\vec{v} = \begin{pmatrix}1\\1\end{pmatrix}
+T(\vec{b}_1) = \vec{c}_1 + \vec{c}_2
+T(\vec{b}_2) = 2\vec{c}_1
+S(\vec{c}_1) = \vec{b}_1
+S(\vec{c}_2) = \vec{b}_2
+`
+
+// traces 在精确解释模式下计算 src，返回每个计算请求的步骤
+func traces(t *testing.T, src string) []Trace {
+	t.Helper()
+	ast, diags := parser.ParseAll("", strings.NewReader(src))
+	if len(diags) > 0 {
+		t.Fatalf("parse: %v", diags)
+	}
+	results, err := Calculate(ast, Options{Exact: true, Explain: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]Trace, len(results))
+	for i, r := range results {
+		if r.Err != nil {
+			t.Fatalf("line %d: %v", r.Line, r.Err)
+		}
+		out[i] = r.Trace
+	}
+	return out
+}
+
+// inOrder 报告 want 中的片段是否按顺序出现在 s 中，返回第一个找不到的片段
+func inOrder(s string, want []string) (string, bool) {
+	for _, w := range want {
+		i := strings.Index(s, w)
+		if i < 0 {
+			return w, false
+		}
+		s = s[i+len(w):]
+	}
+	return "", true
+}
+
+func TestTraceFormats(t *testing.T) {
+	tests := []struct {
+		name  string
+		eval  string
+		text  []string // String 中按顺序出现的片段
+		latex []string // LaTeX 中按顺序出现的片段
+	}{
+		{
+			"pivot swap",
+			`[\vec{v}]_b \leftarrow \text{eval}`,
+			[]string{
+				"solve [b | v]\n    1  3  1\n    2  4  1\n",
+				"pivot in column 1: R2 (2)\nR1 <-> R2\n    2  4  1\n    1  3  1\n",
+				"R2 - 1/2 R1\n",
+				"back substitution\n",
+				"R1 * 1/2\n    1  0  -1/2\n    0  1   1/2",
+			},
+			[]string{
+				"\\begin{aligned}\n&\\text{solve [b | v]} \\\\\n",
+				`\left(\begin{array}{cc|c}1 & 3 & 1\\2 & 4 & 1\end{array}\right) &\xrightarrow{R_1 \leftrightarrow R_2} \left(\begin{array}{cc|c}2 & 4 & 1\\1 & 3 & 1\end{array}\right) \\`,
+				`&\xrightarrow{R_2 - \frac12R_1}`,
+				`&\text{back substitution} \\`,
+				"&\\xrightarrow{\\frac12R_1} \\left(\\begin{array}{cc|c}1 & 0 & -\\frac12\\\\0 & 1 & \\frac12\\end{array}\\right)\n\\end{aligned}",
+			},
+		},
+		{
+			"transition",
+			`P_{b \to c}(\vec{v}) \leftarrow \text{eval}`,
+			[]string{
+				"solve [c | b1]\n    1  1  1\n    0  1  2\n",
+				"solve [c | b2]\n    1  1  3\n    0  1  4\n",
+				"P(v) = [b1]_c + [b2]_c = 1 (-1 2) + 1 (-1 4) = (-2 6) in basis c",
+			},
+			[]string{
+				"&\\text{solve [c | b1]} \\\\\n\\left(\\begin{array}{cc|c}1 & 1 & 1\\\\0 & 1 & 2\\end{array}\\right) \\\\\n&\\text{back substitution} \\\\\n",
+				"&\\text{solve [c | b2]} \\\\\n\\left(\\begin{array}{cc|c}1 & 1 & 3\\\\0 & 1 & 4\\end{array}\\right) \\\\\n",
+				"&\\text{P(v) = [b1]\\_c + [b2]\\_c = 1 (-1 2) + 1 (-1 4) = (-2 6) in basis c}\n\\end{aligned}",
+			},
+		},
+		{
+			"transform note",
+			`T(\vec{v}) \leftarrow \text{eval}`,
+			[]string{"T(v) = T(b1) + T(b2) = 1 (1 1) + 1 (2 0) = (3 1) in basis c"},
+			[]string{"\\begin{aligned}\n&\\text{T(v) = T(b1) + T(b2) = 1 (1 1) + 1 (2 0) = (3 1) in basis c}\n\\end{aligned}"},
+		},
+		{
+			"composition note",
+			`(S \circ T)(\vec{v}) \leftarrow \text{eval}`,
+			[]string{`(S \circ T)(v) = (S \circ T)(b1) + (S \circ T)(b2)`},
+			[]string{`&\text{(S $\circ$ T)(v) = (S $\circ$ T)(b1) + (S $\circ$ T)(b2)`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := traces(t, traceBases+tt.eval+"\n")[0]
+			if s := tr.String(); s == "" {
+				t.Error("String is empty")
+			} else if w, ok := inOrder(s, tt.text); !ok {
+				t.Errorf("String misses %q:\n%s", w, s)
+			}
+			if s := tr.LaTeX(); s == "" {
+				t.Error("LaTeX is empty")
+			} else if w, ok := inOrder(s, tt.latex); !ok {
+				t.Errorf("LaTeX misses %q:\n%s", w, s)
+			}
+		})
+	}
+}
+
+// 过渡矩阵按基对缓存，第二次计算仍然给出完整的步骤
+func TestTraceCachedTransition(t *testing.T) {
+	src := traceBases + `P_{b \to c} \leftarrow \text{eval}
+P_{b \to c}(\vec{v}) \leftarrow \text{eval}
+P_{b \to c}(\vec{v}) \leftarrow \text{eval}
+`
+	tr := traces(t, src)
+	for i := 1; i < len(tr); i++ {
+		if !strings.HasPrefix(tr[i].String(), tr[0].String()) {
+			t.Errorf("eval %d trace does not repeat the solves:\n%s\nwant prefix:\n%s", i+1, tr[i], tr[0])
+		}
+	}
+	if tr[1].String() != tr[2].String() {
+		t.Errorf("traces of the same eval differ:\n%s\nthen:\n%s", tr[1], tr[2])
+	}
+}
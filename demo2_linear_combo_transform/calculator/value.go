@@ -0,0 +1,201 @@
+package calculator
+
+import (
+	"math"
+	"math/big"
+	"strings"
+)
+
+// ValueKind 区分计算结果的种类
+type ValueKind int
+
+const (
+	VectorValue ValueKind = iota // 向量（坐标），如 [\vec{v}]_b、T(\vec{v})
+	ScalarValue                  // 标量，如内积、范数、夹角
+	MatrixValue                  // 矩阵，如变换矩阵 [T]_{b}^{c}，只作为派生的输出
+)
+
+// Value 是一个计算结果，可以是标量或向量
+type Value struct {
+	Kind   ValueKind
+	Vector []Scalar   // VectorValue 的分量
+	Scalar Scalar     // ScalarValue 的值
+	Matrix [][]Scalar // MatrixValue 的元素，按行存放
+
+	// 精确模式下有些标量不是有理数，用以下字段表示它的精确形式：
+	Root *big.Int // 非 nil 时标量为 Scalar·√Root，如范数 2√2
+	Pi   bool     // 为 true 时标量为 Scalar·π，如特殊角 π/4
+}
+
+// vectorValue 构造一个向量结果
+func vectorValue(v []Scalar) Value {
+	return Value{Kind: VectorValue, Vector: v}
+}
+
+// scalarValue 构造一个标量结果
+func scalarValue(s Scalar) Value {
+	return Value{Kind: ScalarValue, Scalar: s}
+}
+
+// matrixValue 构造一个矩阵结果
+func matrixValue(m [][]Scalar) Value {
+	return Value{Kind: MatrixValue, Matrix: m}
+}
+
+// String 返回结果的文本形式：向量为 (x y z)，标量为数值（必要时带 √ 或 π），
+// 矩阵为按列对齐的多行表格
+func (v Value) String() string {
+	if v.Kind == MatrixValue {
+		var sb strings.Builder
+		printMatPretty(&sb, v.Matrix)
+		return strings.TrimSuffix(sb.String(), "\n")
+	}
+	if v.Kind == VectorValue {
+		parts := make([]string, len(v.Vector))
+		for i, x := range v.Vector {
+			parts[i] = x.String()
+		}
+		return "(" + strings.Join(parts, " ") + ")"
+	}
+	switch {
+	case v.Root != nil:
+		return symbolic(v.Scalar, "√"+v.Root.String())
+	case v.Pi:
+		return symbolic(v.Scalar, "π")
+	default:
+		return v.Scalar.String()
+	}
+}
+
+// Float64 返回标量结果的近似浮点值，包括 √ 和 π 因子
+func (v Value) Float64() float64 {
+	f := v.Scalar.Float64()
+	if v.Root != nil {
+		r, _ := new(big.Float).SetInt(v.Root).Float64()
+		f *= math.Sqrt(r)
+	}
+	if v.Pi {
+		f *= math.Pi
+	}
+	return f
+}
+
+// symbolic 把有理系数与符号因子拼成 a·sym/b 的形式，如 2√2、√2/2、3π/4、-π
+func symbolic(coef Scalar, sym string) string {
+	r, ok := coef.(ratScalar)
+	if !ok {
+		return coef.String() + sym
+	}
+	num, den := new(big.Int).Set(r.r.Num()), r.r.Denom()
+	s := ""
+	if num.Sign() < 0 {
+		s = "-"
+		num.Neg(num)
+	}
+	if num.Cmp(big.NewInt(1)) != 0 {
+		s += num.String()
+	}
+	s += sym
+	if den.Cmp(big.NewInt(1)) != 0 {
+		s += "/" + den.String()
+	}
+	return s
+}
+
+// formatCombo 把线性组合写成 2 b1 - b2 的形式，系数为零的项省略，全为零时返回 0
+func formatCombo(coeff []Scalar, names []string) string {
+	var sb strings.Builder
+	for i, c := range coeff {
+		if c.IsZero() {
+			continue
+		}
+		neg := c.Float64() < 0
+		if neg {
+			c = c.Neg()
+		}
+		switch {
+		case sb.Len() == 0 && neg:
+			sb.WriteString("-")
+		case sb.Len() > 0 && neg:
+			sb.WriteString(" - ")
+		case sb.Len() > 0:
+			sb.WriteString(" + ")
+		}
+		if s := c.String(); s != "1" {
+			sb.WriteString(s + " ")
+		}
+		sb.WriteString(names[i])
+	}
+	if sb.Len() == 0 {
+		return "0"
+	}
+	return sb.String()
+}
+
+// LaTeX 返回结果的 LaTeX 形式：向量和矩阵写成 pmatrix，精确模式下分数写成 \frac，
+// 标量的 √ 和 π 因子写成 \sqrt 和 \pi
+func (v Value) LaTeX() string {
+	var rows [][]Scalar
+	switch v.Kind {
+	case ScalarValue:
+		switch {
+		case v.Root != nil:
+			return symbolicLaTeX(v.Scalar, `\sqrt{`+v.Root.String()+`}`)
+		case v.Pi:
+			return symbolicLaTeX(v.Scalar, `\pi`)
+		default:
+			return scalarLaTeX(v.Scalar)
+		}
+	case VectorValue:
+		for _, x := range v.Vector {
+			rows = append(rows, []Scalar{x})
+		}
+	case MatrixValue:
+		rows = v.Matrix
+	}
+	lines := make([]string, len(rows))
+	for i, row := range rows {
+		cells := make([]string, len(row))
+		for j, x := range row {
+			cells[j] = scalarLaTeX(x)
+		}
+		lines[i] = strings.Join(cells, " & ")
+	}
+	return `\begin{pmatrix}` + strings.Join(lines, `\\`) + `\end{pmatrix}`
+}
+
+// scalarLaTeX 返回标量的 LaTeX 形式，分数写成 \frac{a}{b}，分子分母都是一位数时写成 \frac12
+func scalarLaTeX(c Scalar) string {
+	num, den, ok := strings.Cut(c.String(), "/")
+	if !ok {
+		return num
+	}
+	sign := ""
+	if strings.HasPrefix(num, "-") {
+		sign, num = "-", num[1:]
+	}
+	if len(num) == 1 && len(den) == 1 {
+		return sign + `\frac` + num + den
+	}
+	return sign + `\frac{` + num + `}{` + den + `}`
+}
+
+// symbolicLaTeX 是 symbolic 的 LaTeX 形式，如 2\sqrt{2}、\frac{\sqrt{2}}{2}、-\frac{3\pi}{4}
+func symbolicLaTeX(coef Scalar, sym string) string {
+	r, ok := coef.(ratScalar)
+	if !ok {
+		return coef.String() + sym
+	}
+	num, den := new(big.Int).Abs(r.r.Num()), r.r.Denom()
+	s := sym
+	if num.Cmp(big.NewInt(1)) != 0 {
+		s = num.String() + sym
+	}
+	if den.Cmp(big.NewInt(1)) != 0 {
+		s = `\frac{` + s + `}{` + den.String() + `}`
+	}
+	if r.r.Sign() < 0 {
+		s = "-" + s
+	}
+	return s
+}
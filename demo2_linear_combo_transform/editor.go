@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// errInterrupt 表示用户按下 Ctrl-C 放弃了当前输入
+var errInterrupt = errors.New("interrupt")
+
+// maxHistory 是历史记录文件中保留的最大行数
+const maxHistory = 1000
+
+// lineEditor 读取交互式输入的一行
+// 终端支持原始模式时提供行编辑（左右移动、行首行尾、删除）和上下翻阅历史记录，否则逐行读取
+type lineEditor struct {
+	in          *bufio.Reader
+	out         io.Writer
+	fd          int      // 输入终端的文件描述符
+	raw         bool     // 终端是否支持原始模式
+	interactive bool     // 输入是否来自终端，管道输入时不输出提示符
+	history     []string // 历史记录，最新的在最后
+	histFile    string   // 历史记录文件，为空时不保存
+}
+
+// newLineEditor 创建行编辑器，并从 histFile 读取之前会话的历史记录
+// 参数：
+//
+//	in: 输入，通常是标准输入
+//	out: 提示符和回显的输出
+//	histFile: 历史记录文件，为空时不读取也不保存
+//
+// 返回：
+//
+//	*lineEditor: 行编辑器
+func newLineEditor(in *os.File, out io.Writer, histFile string) *lineEditor {
+	e := &lineEditor{in: bufio.NewReader(in), out: out, fd: int(in.Fd()), histFile: histFile}
+	if stat, err := in.Stat(); err == nil && stat.Mode()&os.ModeCharDevice != 0 {
+		e.interactive = true
+		if restore, err := makeRaw(e.fd); err == nil {
+			restore()
+			e.raw = true
+		}
+	}
+	if histFile != "" {
+		if data, err := os.ReadFile(histFile); err == nil {
+			for _, line := range strings.Split(string(data), "\n") {
+				if line != "" {
+					e.history = append(e.history, line)
+				}
+			}
+		}
+		if len(e.history) > maxHistory {
+			e.history = e.history[len(e.history)-maxHistory:]
+		}
+	}
+	return e
+}
+
+// readLine 输出提示符并读取一行，来自终端的非空输入加入历史记录
+// 参数：
+//
+//	prompt: 提示符
+//
+// 返回：
+//
+//	string: 输入的一行，不含换行符
+//	error: 输入结束（Ctrl-D）时为 io.EOF，按下 Ctrl-C 时为 errInterrupt
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	var line string
+	var err error
+	if e.raw {
+		line, err = e.edit(prompt)
+	} else {
+		if e.interactive {
+			fmt.Fprint(e.out, prompt)
+		}
+		line, err = e.in.ReadString('\n')
+		if err == io.EOF && line != "" {
+			err = nil
+		}
+		line = strings.TrimRight(line, "\r\n")
+	}
+	if err == nil {
+		e.remember(line)
+	}
+	return line, err
+}
+
+// remember 把一行加入历史记录并追加到历史记录文件，空行和与上一条相同的行不记录
+// 只记录来自终端的输入，管道输入的脚本不写入历史记录
+func (e *lineEditor) remember(line string) {
+	if !e.interactive || strings.TrimSpace(line) == "" || len(e.history) > 0 && e.history[len(e.history)-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+	if e.histFile == "" {
+		return
+	}
+	f, err := os.OpenFile(e.histFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	fmt.Fprintln(f, line)
+}
+
+// edit 在原始模式下读取一行，支持的按键：
+//
+//	←/→、Ctrl-B/Ctrl-F    左右移动光标
+//	Home/End、Ctrl-A/Ctrl-E 移到行首、行尾
+//	↑/↓、Ctrl-P/Ctrl-N    上一条、下一条历史记录
+//	Backspace、Delete      删除光标前、光标处的字符
+//	Ctrl-K、Ctrl-U        删除到行尾、删除到行首
+//	Ctrl-L                清屏
+//	Ctrl-C                放弃当前输入
+//	Ctrl-D                空行时结束输入，否则同 Delete
+func (e *lineEditor) edit(prompt string) (string, error) {
+	restore, err := makeRaw(e.fd)
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+
+	var buf []rune
+	pos := 0
+	hist := len(e.history) // 正在查看的历史记录下标，等于 len(e.history) 时为当前输入
+	saved := ""            // 翻阅历史记录之前的当前输入
+	refresh := func() {
+		fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(buf))
+		if n := len(buf) - pos; n > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", n)
+		}
+	}
+	recall := func(i int) {
+		if i < 0 || i > len(e.history) || i == hist {
+			return
+		}
+		if hist == len(e.history) {
+			saved = string(buf)
+		}
+		hist = i
+		if i == len(e.history) {
+			buf = []rune(saved)
+		} else {
+			buf = []rune(e.history[i])
+		}
+		pos = len(buf)
+	}
+
+	refresh()
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			fmt.Fprint(e.out, "\r\n")
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(buf), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupt
+		case 4: // Ctrl-D
+			if len(buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+		case 127, 8: // Backspace
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case 1: // Ctrl-A
+			pos = 0
+		case 5: // Ctrl-E
+			pos = len(buf)
+		case 2: // Ctrl-B
+			pos = max(pos-1, 0)
+		case 6: // Ctrl-F
+			pos = min(pos+1, len(buf))
+		case 11: // Ctrl-K
+			buf = buf[:pos]
+		case 21: // Ctrl-U
+			buf = buf[pos:]
+			pos = 0
+		case 16: // Ctrl-P
+			recall(hist - 1)
+		case 14: // Ctrl-N
+			recall(hist + 1)
+		case 12: // Ctrl-L
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case 27: // 转义序列：方向键、Home、End、Delete
+			switch e.escape() {
+			case "A":
+				recall(hist - 1)
+			case "B":
+				recall(hist + 1)
+			case "C":
+				pos = min(pos+1, len(buf))
+			case "D":
+				pos = max(pos-1, 0)
+			case "H", "1~", "7~":
+				pos = 0
+			case "F", "4~", "8~":
+				pos = len(buf)
+			case "3~":
+				if pos < len(buf) {
+					buf = append(buf[:pos], buf[pos+1:]...)
+				}
+			}
+		default:
+			if r < ' ' {
+				continue
+			}
+			buf = append(buf[:pos], append([]rune{r}, buf[pos:]...)...)
+			pos++
+		}
+		refresh()
+	}
+}
+
+// escape 读取 ESC 之后的 CSI（ESC [）或 SS3（ESC O）序列，返回去掉前缀的部分，如 "A"、"3~"
+func (e *lineEditor) escape() string {
+	b, err := e.in.ReadByte()
+	if err != nil || b != '[' && b != 'O' {
+		return ""
+	}
+	var seq []byte
+	for {
+		c, err := e.in.ReadByte()
+		if err != nil {
+			return ""
+		}
+		seq = append(seq, c)
+		// 参数是数字和分号，遇到其他字符序列结束
+		if c < '0' || c > '9' && c != ';' {
+			return string(seq)
+		}
+	}
+}
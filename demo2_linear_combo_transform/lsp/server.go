@@ -0,0 +1,385 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/btsyang/mathlang/calculator"
+	"github.com/btsyang/mathlang/output"
+	"github.com/btsyang/mathlang/parser"
+)
+
+// vecRefRe 匹配文档中对向量的引用，如 \vec{v}、\vec{b}_1、\vec{b}_{12}
+var vecRefRe = regexp.MustCompile(`\\vec\{([a-zA-Z]+)\}(?:_\{?([0-9]+)\}?)?`)
+
+// document 是一个打开的文档及其分析结果，每次修改后整体重新分析
+type document struct {
+	lines   []string // 文档的各行，不含换行符
+	ast     *parser.AST
+	results []calculator.Result
+}
+
+// Server 是 mathlang 笔记的语言服务器，通过标准输入输出上的 JSON-RPC 与编辑器通信
+// 提供诊断信息、向量的悬停提示、跳转到向量定义，以及在计算请求所在行显示结果的 code lens
+type Server struct {
+	opts     calculator.Options
+	sel      *parser.Selection    // 解析文档时的选择条件，如严格模式，nil 时解析全部内容
+	docs     map[string]*document // 打开的文档，键为 URI
+	w        io.Writer
+	shutdown bool  // 是否已收到 shutdown 请求
+	err      error // 写出通知时遇到的错误，Serve 随后返回
+}
+
+// NewServer 创建语言服务器
+// 参数：
+//
+//	opts: 计算选项，决定 code lens 中的结果使用浮点还是精确运算
+//	sel: 解析文档时的选择条件，与命令行的 -strict 等选项相同，可以为 nil
+//
+// 返回：
+//
+//	*Server: 语言服务器
+func NewServer(opts calculator.Options, sel *parser.Selection) *Server {
+	return &Server{opts: opts, sel: sel, docs: make(map[string]*document)}
+}
+
+// Serve 从 r 读取请求和通知，向 w 写出响应和诊断信息，直到收到 exit 通知或输入结束
+// 参数：
+//
+//	r: 输入，通常是标准输入
+//	w: 输出，通常是标准输出
+//
+// 返回：
+//
+//	error: 读写失败，或没有先收到 shutdown 就收到 exit 时返回错误
+func (s *Server) Serve(r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	s.w = w
+	for {
+		body, err := readMessage(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			if err := s.reply(nil, nil, &rpcError{Code: codeParseError, Message: err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("lsp: exit without shutdown")
+			}
+			return nil
+		}
+		result, rerr := s.handle(&req)
+		if s.err != nil {
+			return s.err
+		}
+		if req.ID == nil {
+			// 通知没有响应
+			continue
+		}
+		if err := s.reply(req.ID, result, rerr); err != nil {
+			return err
+		}
+	}
+}
+
+// reply 写出一条响应，rerr 不为 nil 时为错误响应
+func (s *Server) reply(id *json.RawMessage, result any, rerr *rpcError) error {
+	m := map[string]any{"id": id}
+	if rerr != nil {
+		m["error"] = rerr
+	} else {
+		m["result"] = result
+	}
+	return writeMessage(s.w, m)
+}
+
+// notify 写出一条通知，写入失败时记录错误
+func (s *Server) notify(method string, params any) {
+	if s.err == nil {
+		s.err = writeMessage(s.w, map[string]any{"method": method, "params": params})
+	}
+}
+
+// handle 处理一条请求或通知
+// 参数：
+//
+//	req: 请求或通知
+//
+// 返回：
+//
+//	any: 请求的结果，可以为 nil
+//	*rpcError: 请求失败时的错误
+func (s *Server) handle(req *request) (any, *rpcError) {
+	switch req.Method {
+	case "initialize":
+		return map[string]any{
+			"capabilities": map[string]any{
+				"textDocumentSync":   1, // 全量同步
+				"hoverProvider":      true,
+				"definitionProvider": true,
+				"codeLensProvider":   map[string]any{},
+			},
+			"serverInfo": map[string]any{"name": "mathlang"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var p didOpenParams
+		if err := decode(req.Params, &p); err != nil {
+			return nil, err
+		}
+		s.open(p.TextDocument.URI, p.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		var p didChangeParams
+		if err := decode(req.Params, &p); err != nil {
+			return nil, err
+		}
+		if n := len(p.ContentChanges); n > 0 {
+			s.open(p.TextDocument.URI, p.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var p didCloseParams
+		if err := decode(req.Params, &p); err != nil {
+			return nil, err
+		}
+		delete(s.docs, p.TextDocument.URI)
+		// 关闭的文档不再显示诊断信息
+		s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: p.TextDocument.URI, Diagnostics: []diagnostic{}})
+		return nil, nil
+	case "textDocument/hover":
+		var p textDocumentPositionParams
+		if err := decode(req.Params, &p); err != nil {
+			return nil, err
+		}
+		return s.hover(p), nil
+	case "textDocument/definition":
+		var p textDocumentPositionParams
+		if err := decode(req.Params, &p); err != nil {
+			return nil, err
+		}
+		return s.definition(p), nil
+	case "textDocument/codeLens":
+		var p codeLensParams
+		if err := decode(req.Params, &p); err != nil {
+			return nil, err
+		}
+		return s.codeLenses(p.TextDocument.URI), nil
+	default:
+		if req.ID == nil {
+			// 不认识的通知（如 initialized、$/cancelRequest）直接忽略
+			return nil, nil
+		}
+		return nil, &rpcError{Code: codeMethodNotFound, Message: "method not found: " + req.Method}
+	}
+}
+
+// decode 解析请求参数
+func decode(params json.RawMessage, v any) *rpcError {
+	if err := json.Unmarshal(params, v); err != nil {
+		return &rpcError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// open 分析文档的全部内容并发布诊断信息：语法错误、不合法的基和失败的计算请求
+func (s *Server) open(uri, text string) {
+	file := uri
+	if u, err := url.Parse(uri); err == nil && u.Scheme == "file" {
+		file = u.Path
+	}
+	doc := &document{lines: strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")}
+	var pdiags parser.Diagnostics
+	doc.ast, pdiags = parser.ParseSelected(file, strings.NewReader(text), s.sel)
+	pdiags = append(pdiags, calculator.Check(doc.ast, s.opts)...)
+	pdiags.Sort()
+	doc.results, _ = calculator.Calculate(doc.ast, s.opts)
+	s.docs[uri] = doc
+
+	diags := []diagnostic{}
+	for _, d := range pdiags {
+		severity := 1
+		if d.Severity == parser.SeverityWarning {
+			severity = 2
+		}
+		diags = append(diags, diagnostic{
+			Range:    lineRange(d.Source, d.Pos.Line-1, d.Pos.Col-1, d.EndCol-1),
+			Severity: severity,
+			Source:   "mathlang",
+			Message:  d.Msg,
+		})
+	}
+	for _, r := range doc.results {
+		// 不合法的基已经在定义处报告过
+		if r.Err == nil || r.Cascade {
+			continue
+		}
+		line := doc.line(r.Line - 1)
+		diags = append(diags, diagnostic{
+			Range:    lineRange(line, r.Line-1, len(line)-len(strings.TrimLeft(line, " \t")), len(line)),
+			Severity: 1,
+			Source:   "mathlang",
+			Message:  fmt.Sprintf("%s: %v", output.Label(r.Eval), r.Err),
+		})
+	}
+	s.notify("textDocument/publishDiagnostics", publishDiagnosticsParams{URI: uri, Diagnostics: diags})
+}
+
+// hover 返回光标处向量的定义：分量或线性组合，以及所属的基
+func (s *Server) hover(p textDocumentPositionParams) any {
+	doc, ok := s.docs[p.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	name, rng, ok := doc.vecAt(p.Position)
+	if !ok {
+		return nil
+	}
+	v, ok := doc.ast.Vecs[name]
+	if !ok {
+		return nil
+	}
+	text := "```\n" + strings.Join(output.Definition(doc.ast, name), "\n") + "\n```"
+	if v.Pos.Line > 0 {
+		text += fmt.Sprintf("\n\ndefined at line %d", v.Pos.Line)
+	}
+	return hover{Contents: markupContent{Kind: "markdown", Value: text}, Range: rng}
+}
+
+// definition 返回光标处向量的定义位置
+func (s *Server) definition(p textDocumentPositionParams) any {
+	doc, ok := s.docs[p.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	name, _, ok := doc.vecAt(p.Position)
+	if !ok {
+		return nil
+	}
+	v, ok := doc.ast.Vecs[name]
+	if !ok {
+		return nil
+	}
+	line := doc.line(v.Pos.Line - 1)
+	start := v.Pos.Col - 1
+	end := start
+	if i := strings.Index(line[min(start, len(line)):], parser.VecText(name)); i >= 0 {
+		start += i
+		end = start + len(parser.VecText(name))
+	}
+	return location{URI: p.TextDocument.URI, Range: lineRange(line, v.Pos.Line-1, start, end)}
+}
+
+// codeLenses 在每个计算请求所在行返回一个显示结果的 code lens，如 T(\vec{v}) = (0 7)
+func (s *Server) codeLenses(uri string) []codeLens {
+	doc, ok := s.docs[uri]
+	if !ok {
+		return []codeLens{}
+	}
+	lenses := []codeLens{}
+	for _, r := range doc.results {
+		title := output.Label(r.Eval) + " = "
+		switch {
+		case r.Err != nil:
+			title = output.Label(r.Eval) + ": " + r.Err.Error()
+		case r.Value.Kind == calculator.MatrixValue:
+			// code lens 只有一行，矩阵的各行用分号隔开
+			rows := strings.Split(r.Value.String(), "\n")
+			for i, row := range rows {
+				rows[i] = strings.Join(strings.Fields(row), " ")
+			}
+			title += "(" + strings.Join(rows, "; ") + ")"
+		default:
+			title += r.Value.String()
+		}
+		lenses = append(lenses, codeLens{
+			Range:   lineRange(doc.line(r.Line-1), r.Line-1, 0, 0),
+			Command: command{Title: title},
+		})
+	}
+	return lenses
+}
+
+// line 返回第 i 行（从 0 开始），超出范围时为空
+func (d *document) line(i int) string {
+	if i < 0 || i >= len(d.lines) {
+		return ""
+	}
+	return d.lines[i]
+}
+
+// vecAt 查找光标处的向量引用
+// 参数：
+//
+//	pos: 光标位置
+//
+// 返回：
+//
+//	string: 向量名，如 b1
+//	lspRange: 引用在文档中的范围
+//	bool: 光标处是否有向量引用
+func (d *document) vecAt(pos position) (string, lspRange, bool) {
+	line := d.line(pos.Line)
+	at := byteOffset(line, pos.Character)
+	for _, m := range vecRefRe.FindAllStringSubmatchIndex(line, -1) {
+		if m[0] <= at && at <= m[1] {
+			name := line[m[2]:m[3]]
+			if m[4] >= 0 {
+				name += line[m[4]:m[5]]
+			}
+			return name, lineRange(line, pos.Line, m[0], m[1]), true
+		}
+	}
+	return "", lspRange{}, false
+}
+
+// lineRange 把一行中 [start, end) 字节区间转为 LSP 的范围
+func lineRange(line string, n, start, end int) lspRange {
+	return lspRange{
+		Start: position{Line: n, Character: utf16Len(line, start)},
+		End:   position{Line: n, Character: utf16Len(line, max(start, end))},
+	}
+}
+
+// utf16Len 返回 line 的前 n 个字节按 UTF-16 编码的长度，LSP 的列按 UTF-16 编码单元计
+func utf16Len(line string, n int) int {
+	n = min(max(n, 0), len(line))
+	units := 0
+	for _, r := range line[:n] {
+		units++
+		if r >= 0x10000 {
+			units++
+		}
+	}
+	return units
+}
+
+// byteOffset 是 utf16Len 的逆运算，把 UTF-16 列转为字节偏移
+func byteOffset(line string, units int) int {
+	for i, r := range line {
+		if units <= 0 {
+			return i
+		}
+		units--
+		if r >= 0x10000 {
+			units--
+		}
+	}
+	return len(line)
+}
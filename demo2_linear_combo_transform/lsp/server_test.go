@@ -0,0 +1,285 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/btsyang/mathlang/calculator"
+	"github.com/btsyang/mathlang/parser"
+)
+
+const testURI = "file:///tmp/note.org"
+
+// testNote 的第 4 行是含有 emoji 的标题，第 7 行的分量不合法，用于检查 UTF-16 列
+var testNote = strings.Join([]string{
+	`\vec{b}_1 = \begin{pmatrix}1\\0\end{pmatrix}`,
+	`\vec{b}_2 = \begin{pmatrix}0\\1\end{pmatrix}`,
+	`b = \{\vec{b}_1, \vec{b}_2\}`,
+	`\vec{v} = \begin{pmatrix}1\\2\end{pmatrix}`,
+	`* 😀 über \vec{v}`,
+	`[\vec{v}]_b \leftarrow \text{eval}`,
+	`P_{b \to b} \leftarrow \text{eval}`,
+	`\vec{w} = \begin{pmatrix}1\\😀\end{pmatrix}`,
+}, "\n") + "\n"
+
+// message 是服务器写出的一条响应或通知
+type message struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+// serve 把 msgs 依次发给一个新的服务器，返回 Serve 的错误和服务器写出的全部消息
+func serve(t *testing.T, msgs ...map[string]any) ([]message, error) {
+	t.Helper()
+	return serveWith(t, NewServer(calculator.Options{Exact: true}, nil), msgs...)
+}
+
+// serveWith 同 serve，使用给定的服务器
+func serveWith(t *testing.T, srv *Server, msgs ...map[string]any) ([]message, error) {
+	t.Helper()
+	var in, out bytes.Buffer
+	for _, m := range msgs {
+		body, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&in, "Content-Length: %d\r\n\r\n%s", len(body), body)
+	}
+	err := srv.Serve(&in, &out)
+	var got []message
+	for _, part := range strings.Split(out.String(), "Content-Length: ")[1:] {
+		_, body, _ := strings.Cut(part, "\r\n\r\n")
+		var m message
+		if err := json.Unmarshal([]byte(body), &m); err != nil {
+			t.Fatalf("bad message %q: %v", body, err)
+		}
+		got = append(got, m)
+	}
+	return got, err
+}
+
+func openNote(text string) map[string]any {
+	return map[string]any{"method": "textDocument/didOpen", "params": map[string]any{
+		"textDocument": map[string]any{"uri": testURI, "text": text}}}
+}
+
+func at(id int, method string, line, char int) map[string]any {
+	return map[string]any{"id": id, "method": method, "params": map[string]any{
+		"textDocument": map[string]any{"uri": testURI},
+		"position":     map[string]any{"line": line, "character": char}}}
+}
+
+// response 把 ID 为 id 的响应的结果解析到 v
+func response(t *testing.T, msgs []message, id int, v any) {
+	t.Helper()
+	for _, m := range msgs {
+		if m.ID != nil && *m.ID == id {
+			if m.Error != nil {
+				t.Fatalf("request %d failed: %s", id, m.Error.Message)
+			}
+			if err := json.Unmarshal(m.Result, v); err != nil {
+				t.Fatalf("request %d: %v", id, err)
+			}
+			return
+		}
+	}
+	t.Fatalf("no response to request %d", id)
+}
+
+func TestServeLifecycle(t *testing.T) {
+	msgs, err := serve(t,
+		map[string]any{"id": 1, "method": "initialize", "params": map[string]any{}},
+		map[string]any{"method": "initialized", "params": map[string]any{}},
+		map[string]any{"id": 2, "method": "no/such/method"},
+		map[string]any{"id": 3, "method": "shutdown"},
+		map[string]any{"method": "exit"},
+	)
+	if err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	var init struct {
+		Capabilities map[string]any `json:"capabilities"`
+	}
+	response(t, msgs, 1, &init)
+	for _, c := range []string{"textDocumentSync", "hoverProvider", "definitionProvider", "codeLensProvider"} {
+		if _, ok := init.Capabilities[c]; !ok {
+			t.Errorf("capability %s missing", c)
+		}
+	}
+	if len(msgs) != 3 || msgs[1].Error == nil || msgs[1].Error.Code != codeMethodNotFound {
+		t.Errorf("want responses to initialize, the unknown method and shutdown only, got %+v", msgs)
+	}
+
+	if _, err := serve(t, map[string]any{"method": "exit"}); err == nil {
+		t.Error("exit without shutdown: want an error")
+	}
+}
+
+func TestPublishDiagnostics(t *testing.T) {
+	msgs, err := serve(t, openNote(testNote),
+		map[string]any{"method": "textDocument/didClose", "params": map[string]any{"textDocument": map[string]any{"uri": testURI}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 {
+		t.Fatalf("got %d messages, want 2 publishDiagnostics", len(msgs))
+	}
+	var open, closed publishDiagnosticsParams
+	json.Unmarshal(msgs[0].Params, &open)
+	json.Unmarshal(msgs[1].Params, &closed)
+	if msgs[0].Method != "textDocument/publishDiagnostics" || open.URI != testURI || len(open.Diagnostics) != 1 {
+		t.Fatalf("got %+v, want one diagnostic for %s", msgs[0], testURI)
+	}
+	// 😀 在第 28 个字节，占两个 UTF-16 编码单元
+	d := open.Diagnostics[0]
+	want := lspRange{Start: position{Line: 7, Character: 28}, End: position{Line: 7, Character: 30}}
+	if d.Range != want || d.Severity != 1 || !strings.Contains(d.Message, "invalid component value") {
+		t.Errorf("got %+v, want an error at %+v", d, want)
+	}
+	if len(closed.Diagnostics) != 0 {
+		t.Errorf("didClose published %d diagnostics, want none", len(closed.Diagnostics))
+	}
+}
+
+func TestHoverAndDefinition(t *testing.T) {
+	msgs, err := serve(t, openNote(testNote),
+		// 标题 "* 😀 über \vec{v}" 中 \vec{v} 从 UTF-16 第 10 列开始，第 13 个字节
+		at(1, "textDocument/hover", 4, 12),
+		at(2, "textDocument/definition", 4, 10),
+		at(3, "textDocument/definition", 2, 8),
+		at(4, "textDocument/hover", 4, 3),
+		at(5, "textDocument/hover", 7, 1),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var h *hover
+	response(t, msgs, 1, &h)
+	if h == nil {
+		t.Fatal("no hover for \\vec{v}")
+	}
+	if want := (lspRange{Start: position{Line: 4, Character: 10}, End: position{Line: 4, Character: 17}}); h.Range != want {
+		t.Errorf("hover range %+v, want %+v", h.Range, want)
+	}
+	if !strings.Contains(h.Contents.Value, `\vec{v} = (1 2)`) || !strings.Contains(h.Contents.Value, "defined at line 4") {
+		t.Errorf("hover contents %q", h.Contents.Value)
+	}
+
+	for _, tt := range []struct {
+		id   int
+		want lspRange
+	}{
+		{2, lspRange{Start: position{Line: 3, Character: 0}, End: position{Line: 3, Character: 7}}},
+		{3, lspRange{Start: position{Line: 0, Character: 0}, End: position{Line: 0, Character: 9}}},
+	} {
+		var loc *location
+		response(t, msgs, tt.id, &loc)
+		if loc == nil || loc.URI != testURI || loc.Range != tt.want {
+			t.Errorf("definition %d: got %+v, want %+v", tt.id, loc, tt.want)
+		}
+	}
+
+	// emoji 上没有向量；\vec{w} 的定义失败，没有悬停提示
+	for _, id := range []int{4, 5} {
+		var none *hover
+		response(t, msgs, id, &none)
+		if none != nil {
+			t.Errorf("hover %d: got %+v, want null", id, none)
+		}
+	}
+}
+
+func TestCodeLens(t *testing.T) {
+	msgs, err := serve(t, openNote(testNote),
+		map[string]any{"id": 1, "method": "textDocument/codeLens", "params": map[string]any{"textDocument": map[string]any{"uri": testURI}}},
+		map[string]any{"id": 2, "method": "textDocument/codeLens", "params": map[string]any{"textDocument": map[string]any{"uri": "file:///other.org"}}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lenses []codeLens
+	response(t, msgs, 1, &lenses)
+	want := []struct {
+		line  int
+		title string
+	}{
+		{5, `[\vec{v}]_b = (1 2)`},
+		{6, `P_{b \to b} = (1 0; 0 1)`},
+	}
+	if len(lenses) != len(want) {
+		t.Fatalf("got %d code lenses, want %d: %+v", len(lenses), len(want), lenses)
+	}
+	for i, w := range want {
+		if lenses[i].Range.Start.Line != w.line || lenses[i].Command.Title != w.title {
+			t.Errorf("lens %d: got line %d %q, want line %d %q", i, lenses[i].Range.Start.Line, lenses[i].Command.Title, w.line, w.title)
+		}
+	}
+	var none []codeLens
+	response(t, msgs, 2, &none)
+	if none == nil || len(none) != 0 {
+		t.Errorf("unknown document: got %+v, want an empty list", none)
+	}
+}
+
+func TestUTF16Columns(t *testing.T) {
+	tests := []struct {
+		line  string
+		bytes int
+		units int
+	}{
+		{`\vec{v}`, 5, 5},
+		{"über v", 3, 2},
+		{"😀 v", 4, 2},
+		{"😀 v", 5, 3},
+		{"中文 v", 6, 2},
+		{"ab", 9, 2}, // 超出行尾
+	}
+	for _, tt := range tests {
+		if got := utf16Len(tt.line, tt.bytes); got != tt.units {
+			t.Errorf("utf16Len(%q, %d) = %d, want %d", tt.line, tt.bytes, got, tt.units)
+		}
+		if got, want := byteOffset(tt.line, tt.units), min(tt.bytes, len(tt.line)); got != want {
+			t.Errorf("byteOffset(%q, %d) = %d, want %d", tt.line, tt.units, got, want)
+		}
+	}
+}
+
+// 严格模式下只有 mathlang 块中的计算请求有 code lens，块外看起来像语句的行不报告错误
+func TestStrictMode(t *testing.T) {
+	note := strings.Join([]string{
+		`#+begin_src mathlang`,
+		`\vec{v} = \begin{pmatrix}1\\2\end{pmatrix}`,
+		`\|\vec{v}\| \leftarrow \text{eval}`,
+		`#+end_src`,
+		`\vec{w} = \begin{pmatrix}1\\q\end{pmatrix}`,
+		`\|\vec{v}\| \leftarrow \text{eval}`,
+	}, "\n")
+	lens := map[string]any{"id": 1, "method": "textDocument/codeLens", "params": map[string]any{"textDocument": map[string]any{"uri": testURI}}}
+	for _, tt := range []struct {
+		sel    *parser.Selection
+		lenses int
+		diags  int
+	}{
+		{nil, 2, 1},
+		{&parser.Selection{Strict: true}, 1, 0},
+	} {
+		msgs, err := serveWith(t, NewServer(calculator.Options{Exact: true}, tt.sel), openNote(note), lens)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pub publishDiagnosticsParams
+		json.Unmarshal(msgs[0].Params, &pub)
+		var lenses []codeLens
+		response(t, msgs, 1, &lenses)
+		if len(pub.Diagnostics) != tt.diags || len(lenses) != tt.lenses {
+			t.Errorf("strict %v: got %d diagnostics and %d code lenses, want %d and %d",
+				tt.sel != nil, len(pub.Diagnostics), len(lenses), tt.diags, tt.lenses)
+		}
+	}
+}
@@ -1,30 +1,67 @@
 package main
 
 import (
+	"bytes"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"strings"
 
 	// "mathlang/calculator"
 	"github.com/btsyang/mathlang/calculator"
+	"github.com/btsyang/mathlang/lsp"
+	"github.com/btsyang/mathlang/output"
 	"github.com/btsyang/mathlang/parser"
 )
 
 // main 是程序的入口点，处理命令行参数，读取输入，解析表达式，执行计算并输出结果
 func main() {
+	// 子命令：mathlang repl 进入交互式解释器，mathlang lsp 启动语言服务器，mathlang fmt 格式化笔记
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "repl":
+			runREPL(os.Args[2:])
+			return
+		case "lsp":
+			runLSP(os.Args[2:])
+			return
+		case "fmt":
+			runFmt(os.Args[2:])
+			return
+		}
+	}
+
+	exact := flag.Bool("exact", false, "使用 big.Rat 做精确的有理数运算，结果输出为约分后的分数")
+	matrix := flag.String("matrix", "table", "矩阵结果的输出形式：table（按列对齐的文本表格）或 latex（pmatrix）")
+	explain := flag.Bool("explain", false, "在每个结果之后输出求解过程：选主元、行交换、消元和回代，形式由 -matrix 决定")
+	format := flag.String("format", "text", "输出格式：text（每个结果一行，诊断信息输出到标准错误）、latex（每个结果一个 \\[ ... \\] 公式块）或 json（定义、结果和诊断信息组成的一个 JSON 文档）")
+	writeBack := flag.Bool("write-back", false, "把计算结果写回输入文件中的 #+RESULTS: mathlang 结果块，重复运行会替换旧的结果块，删除没有新结果的结果块")
+	writeBackAt := flag.String("write-back-at", "statement", "结果块的位置：statement（每个计算请求之后）或 heading（Check result 标题之下）")
+	skipStatus := flag.String("skip-status", "", "跳过 Status 属性为其中之一的 org 子树，多个值用逗号分隔，如 draft")
+	skipTags := flag.String("skip-tags", "", "跳过带有其中任一标签的 org 子树，多个标签用逗号分隔")
+	sections := flag.String("section", "", "只执行标题为其中之一的 org 子树中的计算请求（定义仍全部读取），多个标题用逗号分隔")
+	strict := flag.Bool("strict", false, "严格模式：只解释 #+begin_src mathlang ... #+end_src 源代码块和紧接在 #+mathlang 指令行之后的 \\[ ... \\] 公式块，其余内容都视为正文")
+	flag.Parse()
+	if *format != "text" && *format != "json" && *format != "latex" {
+		log.Fatalf("unknown output format: %s", *format)
+	}
+
 	var file io.Reader
-	if len(os.Args) < 2 {
-		// 没有提供文件参数，从标准输入读取
-		fmt.Println("从标准输入读取输入...")
+	srcName := "<stdin>"
+	if flag.NArg() < 1 {
+		// 没有提供文件参数，从标准输入读取；提示输出到标准错误，不混入 json 和 latex 结果
+		fmt.Fprintln(os.Stderr, "从标准输入读取输入...")
 		file = os.Stdin
 	} else {
 		// 从文件读取
-		f, err := os.Open(os.Args[1])
+		f, err := os.Open(flag.Arg(0))
 		if err != nil {
 			log.Fatal(err)
 		}
 		defer f.Close()
+		srcName = flag.Arg(0)
 		stat, _ := f.Stat()
 		log.Printf("file size: %d bytes", stat.Size())
 		f.Seek(0, 0)
@@ -32,32 +69,188 @@ func main() {
 	}
 	// =========================================
 
-	// 喂给 parser
-	ast, err := parser.ParseReader(file)
-	if err != nil {
+	// 喂给 parser，以容错模式一次报告所有问题，诊断信息在输出阶段按所选格式输出
+	sel := &parser.Selection{SkipStatus: list(*skipStatus), SkipTags: list(*skipTags), Sections: list(*sections), Strict: *strict}
+	ast, diags := parser.ParseSelected(flag.Arg(0), file, sel)
+	// 基的合法性（维数、线性无关）需要计算，在计算请求之前统一检查，报告在基的定义处
+	opts := calculator.Options{Exact: *exact, Explain: *explain}
+	diags = append(diags, calculator.Check(ast, opts)...)
+	diags.Sort()
+	// 计算
+	results, err := calculator.Calculate(ast, opts)
+
+	// 3. 输出
+	switch *format {
+	case "json":
+		// 诊断信息也写入 JSON，标准输出上只有一个文档
+		if werr := output.JSON(os.Stdout, flag.Arg(0), ast, diags, results, opts); werr != nil {
+			log.Fatal(werr)
+		}
+	case "latex":
+		for _, d := range diags {
+			fmt.Fprint(os.Stderr, d.Format())
+		}
+		printLaTeX(results, srcName)
+	default:
+		for _, d := range diags {
+			fmt.Fprint(os.Stderr, d.Format())
+		}
+		printText(os.Stdout, os.Stderr, results, srcName, *matrix)
+	}
+	if *writeBack {
+		if flag.NArg() < 1 {
+			log.Fatal("write-back: needs an input file")
+		}
+		if werr := writeBackFile(flag.Arg(0), results, *writeBackAt); werr != nil {
+			log.Fatal(werr)
+		}
+	}
+	if err != nil || diags.HasErrors() {
+		os.Exit(1)
+	}
+}
+
+// runLSP 在标准输入输出上运行语言服务器：mathlang lsp [-exact] [-strict]
+func runLSP(args []string) {
+	fs := flag.NewFlagSet("lsp", flag.ExitOnError)
+	exact := fs.Bool("exact", false, "code lens 中的结果使用 big.Rat 做精确的有理数运算")
+	strict := fs.Bool("strict", false, "严格模式：只解释 mathlang 源代码块和 #+mathlang 指令之后的公式块，同主命令的 -strict")
+	fs.Parse(args)
+	sel := &parser.Selection{Strict: *strict}
+	if err := lsp.NewServer(calculator.Options{Exact: *exact}, sel).Serve(os.Stdin, os.Stdout); err != nil {
 		log.Fatal(err)
 	}
+}
 
-	// 3. 计算
-	if ast.Eval != nil {
-		// res := calculator.Calculate(ast)
-		res, err := calculator.Calculate(ast)
+// runFmt 把笔记中的语句改写为规范写法：mathlang fmt [-w] [-l] [file ...]
+// 没有文件参数时从标准输入读取，结果写到标准输出
+func runFmt(args []string) {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := fs.Bool("w", false, "把结果写回文件，而不是输出到标准输出")
+	listOnly := fs.Bool("l", false, "只列出格式需要改变的文件")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		src, err := io.ReadAll(os.Stdin)
 		if err != nil {
 			log.Fatal(err)
 		}
-		// 根据计算类型显示不同的输出格式
-		switch e := ast.Eval.(type) {
-		case *parser.EvalChangeBasis:
-			fmt.Printf("[\\vec{%s}]_%s = (", e.Vec.Name, e.Basis.Name)
-		case *parser.EvalTransform:
-			fmt.Printf("%s(\\vec{%s}) = (", e.Transform, e.Vec.Name)
+		out, err := parser.Format(src)
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Stdout.Write(out)
+		return
+	}
+	failed := false
+	for _, name := range fs.Args() {
+		src, err := os.ReadFile(name)
+		if err == nil {
+			var out []byte
+			if out, err = parser.Format(src); err == nil {
+				switch {
+				case *listOnly:
+					if !bytes.Equal(src, out) {
+						fmt.Println(name)
+					}
+				case *write:
+					if !bytes.Equal(src, out) {
+						err = writeFile(name, out)
+					}
+				default:
+					os.Stdout.Write(out)
+				}
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// printText 以文本形式把计算结果写到 w、计算错误写到 errw，每个计算请求输出一行，矩阵按 matrix 指定的形式输出
+func printText(w, errw io.Writer, results []calculator.Result, srcName, matrix string) {
+	for _, r := range results {
+		if r.Err != nil {
+			if !r.Cascade {
+				fmt.Fprintf(errw, "%s:%d: error: %v\n", srcName, r.Line, r.Err)
+			}
+			continue
+		}
+		switch {
+		case r.Value.Kind != calculator.MatrixValue:
+			fmt.Fprintf(w, "%s = %s\n", output.Label(r.Eval), r.Value)
+		case matrix == "latex":
+			fmt.Fprintf(w, "%s = %s\n", output.Label(r.Eval), r.Value.LaTeX())
+		default:
+			fmt.Fprintf(w, "%s =\n%s\n", output.Label(r.Eval), r.Value)
+		}
+		// 解释模式下在结果之后输出求解过程
+		if len(r.Trace) > 0 {
+			if matrix == "latex" {
+				fmt.Fprintln(w, r.Trace.LaTeX())
+			} else {
+				fmt.Fprintln(w, "  "+strings.ReplaceAll(r.Trace.String(), "\n", "\n  "))
+			}
 		}
-		for i, x := range res {
-			if i > 0 {
-				fmt.Print(" ")
+	}
+}
+
+// printLaTeX 以 LaTeX 公式块输出计算结果，可以直接粘贴回笔记中，如
+//
+//	\[ [\vec{v}]_b = \begin{pmatrix}-\frac12\\\frac12\end{pmatrix} \]
+func printLaTeX(results []calculator.Result, srcName string) {
+	for _, r := range results {
+		if r.Err != nil {
+			if !r.Cascade {
+				fmt.Fprintf(os.Stderr, "%s:%d: error: %v\n", srcName, r.Line, r.Err)
 			}
-			fmt.Print(x)
+			continue
+		}
+		fmt.Printf("\\[ %s = %s \\]\n", output.Label(r.Eval), r.Value.LaTeX())
+		// 解释模式下在结果之后输出求解过程
+		if len(r.Trace) > 0 {
+			fmt.Printf("\\[\n%s\n\\]\n", r.Trace.LaTeX())
+		}
+	}
+}
+
+// writeBackFile 把计算结果写回文件，文件内容没有变化时不写入
+func writeBackFile(name string, results []calculator.Result, at string) error {
+	src, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	out, err := output.WriteBack(src, results, at)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(src, out) {
+		return nil
+	}
+	return writeFile(name, out)
+}
+
+// writeFile 用新内容覆盖文件，保留原来的权限
+func writeFile(name string, data []byte) error {
+	stat, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, data, stat.Mode().Perm())
+}
+
+// list 把逗号分隔的命令行参数拆成列表，忽略空项
+func list(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
 		}
-		fmt.Println(")")
 	}
+	return out
 }
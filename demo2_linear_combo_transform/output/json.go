@@ -0,0 +1,239 @@
+package output
+
+import (
+	"encoding/json"
+	"io"
+	"math/big"
+	"sort"
+
+	"github.com/btsyang/mathlang/calculator"
+	"github.com/btsyang/mathlang/parser"
+)
+
+// jsonPos 是源位置的 JSON 形式，Col 为 0 表示只知道行号
+type jsonPos struct {
+	File string `json:"file"`
+	Line int    `json:"line"`
+	Col  int    `json:"col,omitempty"`
+
+	Section string `json:"section,omitempty"` // 所在的 org 标题路径，如 LinCombTransform / Evaluate
+}
+
+type jsonVector struct {
+	Name       string   `json:"name"`
+	Components []string `json:"components,omitempty"` // 向量的分量；线性组合定义的向量为求值后的分量，维数不一致时为空
+	Expr       string   `json:"expr,omitempty"`       // 线性组合定义的向量的表达式，如 2 u - v
+	Basis      string   `json:"basis,omitempty"`      // 向量所属的基
+	Pos        jsonPos  `json:"pos"`
+}
+
+type jsonBasis struct {
+	Name    string   `json:"name"`
+	Vectors []string `json:"vectors"`
+	Pos     jsonPos  `json:"pos"`
+}
+
+type jsonTransform struct {
+	Name  string            `json:"name"`
+	From  string            `json:"from"`
+	To    string            `json:"to"`
+	Rules map[string]string `json:"rules"` // 输入基向量 → 像在输出基下的线性组合
+	Pos   jsonPos           `json:"pos"`
+}
+
+type jsonValue struct {
+	Type   string     `json:"type"` // vector、scalar 或 matrix
+	Text   string     `json:"text"` // 与文本输出相同的写法，如 (1/2 -1/2)、√2
+	Vector []string   `json:"vector,omitempty"`
+	Matrix [][]string `json:"matrix,omitempty"`
+	Float  any        `json:"float"` // 近似浮点值，形状与 vector、matrix 相同，标量为单个数
+}
+
+type jsonStep struct {
+	Text   string     `json:"text"`
+	Matrix [][]string `json:"matrix,omitempty"`
+}
+
+type jsonResult struct {
+	Kind  string     `json:"kind"`  // 计算请求的种类，如 change_basis、transform
+	Label string     `json:"label"` // 计算请求的写法，如 [\vec{v}]_b
+	Pos   jsonPos    `json:"pos"`
+	Value *jsonValue `json:"value,omitempty"`
+	Error string     `json:"error,omitempty"`
+	// Cascade 表示错误是用到的基不合法，diagnostics 中已有基的定义处的错误
+	Cascade bool       `json:"cascade,omitempty"`
+	Trace   []jsonStep `json:"trace,omitempty"` // 解释模式下的求解步骤
+}
+
+type jsonDiagnostic struct {
+	Severity string  `json:"severity"`
+	Pos      jsonPos `json:"pos"`
+	EndCol   int     `json:"end_col"`
+	Message  string  `json:"message"`
+}
+
+type jsonDocument struct {
+	Exact       bool             `json:"exact"`
+	Vectors     []jsonVector     `json:"vectors"`
+	Bases       []jsonBasis      `json:"bases"`
+	Transforms  []jsonTransform  `json:"transforms"`
+	Results     []jsonResult     `json:"results"`
+	Diagnostics []jsonDiagnostic `json:"diagnostics"`
+}
+
+// JSON 把所有定义、计算结果和诊断信息写成一个 JSON 文档，供评分脚本和编辑器插件读取
+// 定义按源代码中的位置排序；数值同时给出文本形式（精确模式下为分数）和近似浮点值
+// 参数：
+//
+//	w: 输出目标
+//	file: 源文件名，从标准输入读取时为空
+//	ast: 抽象语法树
+//	diags: 语法分析和检查得到的诊断信息
+//	results: 计算结果
+//	opts: 计算选项
+//
+// 返回：
+//
+//	error: 写入失败时返回错误
+func JSON(w io.Writer, file string, ast *parser.AST, diags parser.Diagnostics, results []calculator.Result, opts calculator.Options) error {
+	doc := jsonDocument{
+		Exact:       opts.Exact,
+		Vectors:     []jsonVector{},
+		Bases:       []jsonBasis{},
+		Transforms:  []jsonTransform{},
+		Results:     []jsonResult{},
+		Diagnostics: []jsonDiagnostic{},
+	}
+
+	for _, v := range ast.Vecs {
+		jv := jsonVector{Name: v.Name, Pos: posOf(ast, v.Pos)}
+		if v.Expr != nil {
+			jv.Expr = combo(v.Expr)
+			if comp, err := calculator.Components(v, opts); err == nil {
+				jv.Components = strings1(comp)
+			}
+		} else {
+			for i, f := range v.Comp {
+				var r *big.Rat
+				if i < len(v.Exact) {
+					r = v.Exact[i]
+				}
+				jv.Components = append(jv.Components, literal(f, r))
+			}
+		}
+		if v.Basis != nil {
+			jv.Basis = v.Basis.Name
+		}
+		doc.Vectors = append(doc.Vectors, jv)
+	}
+	sort.Slice(doc.Vectors, func(i, j int) bool { return before(doc.Vectors[i].Pos, doc.Vectors[j].Pos) })
+
+	for _, b := range ast.Bases {
+		jb := jsonBasis{Name: b.Name, Vectors: []string{}, Pos: posOf(ast, b.Pos)}
+		for _, v := range b.Vecs {
+			jb.Vectors = append(jb.Vectors, v.Name)
+		}
+		doc.Bases = append(doc.Bases, jb)
+	}
+	sort.Slice(doc.Bases, func(i, j int) bool { return before(doc.Bases[i].Pos, doc.Bases[j].Pos) })
+
+	for _, t := range ast.Transforms {
+		jt := jsonTransform{Name: t.Name, From: t.FromBasis.Name, To: t.ToBasis.Name, Rules: map[string]string{}, Pos: posOf(ast, t.Pos)}
+		for v, terms := range t.Map {
+			jt.Rules[v] = combo(terms)
+		}
+		doc.Transforms = append(doc.Transforms, jt)
+	}
+	sort.Slice(doc.Transforms, func(i, j int) bool { return before(doc.Transforms[i].Pos, doc.Transforms[j].Pos) })
+
+	for _, r := range results {
+		jr := jsonResult{Kind: Kind(r.Eval), Label: Label(r.Eval), Pos: posOf(ast, parser.Pos{File: file, Line: r.Line, Col: r.Eval.SourceCol()})}
+		if r.Err != nil {
+			jr.Error = r.Err.Error()
+			jr.Cascade = r.Cascade
+		} else {
+			jr.Value = valueOf(r.Value)
+		}
+		for _, s := range r.Trace {
+			jr.Trace = append(jr.Trace, jsonStep{Text: s.Text(), Matrix: strings2(s.Matrix)})
+		}
+		doc.Results = append(doc.Results, jr)
+	}
+
+	for _, d := range diags {
+		doc.Diagnostics = append(doc.Diagnostics, jsonDiagnostic{
+			Severity: d.Severity.String(),
+			Pos:      posOf(ast, d.Pos),
+			EndCol:   d.EndCol,
+			Message:  d.Msg,
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(doc)
+}
+
+// valueOf 把计算结果转为 JSON 形式
+func valueOf(v calculator.Value) *jsonValue {
+	jv := &jsonValue{Text: v.String()}
+	switch v.Kind {
+	case calculator.VectorValue:
+		jv.Type = "vector"
+		jv.Vector = strings1(v.Vector)
+		jv.Float = floats1(v.Vector)
+	case calculator.MatrixValue:
+		jv.Type = "matrix"
+		jv.Matrix = strings2(v.Matrix)
+		floats := make([][]float64, len(v.Matrix))
+		for i, row := range v.Matrix {
+			floats[i] = floats1(row)
+		}
+		jv.Float = floats
+	default:
+		jv.Type = "scalar"
+		jv.Float = v.Float64()
+	}
+	return jv
+}
+
+func strings1(xs []calculator.Scalar) []string {
+	out := make([]string, len(xs))
+	for i, x := range xs {
+		out[i] = x.String()
+	}
+	return out
+}
+
+func strings2(rows [][]calculator.Scalar) [][]string {
+	if rows == nil {
+		return nil
+	}
+	out := make([][]string, len(rows))
+	for i, row := range rows {
+		out[i] = strings1(row)
+	}
+	return out
+}
+
+func floats1(xs []calculator.Scalar) []float64 {
+	out := make([]float64, len(xs))
+	for i, x := range xs {
+		out[i] = x.Float64()
+	}
+	return out
+}
+
+// posOf 把源位置转为 JSON 形式，并查出它所在的 org 标题
+func posOf(ast *parser.AST, p parser.Pos) jsonPos {
+	return jsonPos{File: p.File, Line: p.Line, Col: p.Col, Section: ast.HeadingAt(p.Line).Path()}
+}
+
+// before 报告 p 是否在 q 之前
+func before(p, q jsonPos) bool {
+	if p.Line != q.Line {
+		return p.Line < q.Line
+	}
+	return p.Col < q.Col
+}
@@ -0,0 +1,52 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/btsyang/mathlang/calculator"
+	"github.com/btsyang/mathlang/parser"
+)
+
+func TestJSON(t *testing.T) {
+	src := wbBases + `\vec{w} = \frac12\vec{v} - \vec{b}_1
+  \[ [\vec{w}]_b \leftarrow \text{eval} \quad \|\vec{v}\| \leftarrow \text{eval} \]
+`
+	opts := calculator.Options{Exact: true}
+	ast, diags := parser.ParseAll("note.org", strings.NewReader(src))
+	results, _ := calculator.Calculate(ast, opts)
+	var buf bytes.Buffer
+	if err := JSON(&buf, "note.org", ast, diags, results, opts); err != nil {
+		t.Fatal(err)
+	}
+	var doc jsonDocument
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+
+	// 线性组合定义的向量同时给出表达式和求值后的分量
+	w := doc.Vectors[len(doc.Vectors)-1]
+	if w.Name != "w" || w.Expr != "1/2 v - b1" || strings.Join(w.Components, " ") != "-1/2 1" {
+		t.Errorf("vector w: %+v", w)
+	}
+
+	// 结果的位置与诊断信息一样带有列号
+	want := []struct {
+		label     string
+		line, col int
+	}{
+		{`[\vec{w}]_b`, 7, 6},
+		{`\|\vec{v}\|`, 7, 47},
+	}
+	if len(doc.Results) != len(want) {
+		t.Fatalf("%d results, want %d", len(doc.Results), len(want))
+	}
+	for i, w := range want {
+		r := doc.Results[i]
+		if r.Label != w.label || r.Pos.Line != w.line || r.Pos.Col != w.col || r.Pos.Section != "Note" {
+			t.Errorf("result %d: %s at %+v, want %s at %d:%d", i, r.Label, r.Pos, w.label, w.line, w.col)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package output
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/btsyang/mathlang/parser"
+)
+
+// Label 返回计算请求在结果中的写法（不含等号），如 [\vec{v}]_b、T(\vec{b}_1)、[T]_{b}^{c}
+// 向量按笔记中的写法输出，带下标的向量写作 \vec{b}_1 而不是 \vec{b1}
+// 参数：
+//
+//	e: 计算请求
+//
+// 返回：
+//
+//	string: LaTeX 风格的写法
+func Label(e parser.EvalStmt) string {
+	switch e := e.(type) {
+	case *parser.EvalChangeBasis:
+		return fmt.Sprintf("[%s]_%s", parser.VecText(e.Vec.Name), e.Basis.Name)
+	case *parser.EvalTransform:
+		return fmt.Sprintf("%s(%s)", e.Transform, parser.VecText(e.Vec.Name))
+	case *parser.EvalCompose:
+		return fmt.Sprintf("(%s)(%s)", strings.Join(e.Transforms, ` \circ `), parser.VecText(e.Vec.Name))
+	case *parser.EvalTransformMatrix:
+		return fmt.Sprintf("[%s]_{%s}^{%s}", e.Transform, e.From.Name, e.To.Name)
+	case *parser.EvalTransition:
+		if e.Vec != nil {
+			return fmt.Sprintf("P_{%s \\to %s}(%s)", e.From.Name, e.To.Name, parser.VecText(e.Vec.Name))
+		}
+		return fmt.Sprintf("P_{%s \\to %s}", e.From.Name, e.To.Name)
+	case *parser.EvalInner:
+		return fmt.Sprintf("\\langle %s, %s \\rangle", parser.VecText(e.U.Name), parser.VecText(e.V.Name))
+	case *parser.EvalNorm:
+		return fmt.Sprintf("\\|%s\\|", parser.VecText(e.Vec.Name))
+	case *parser.EvalAngle:
+		return fmt.Sprintf("\\angle(%s, %s)", parser.VecText(e.U.Name), parser.VecText(e.V.Name))
+	default:
+		return "?"
+	}
+}
+
+// Kind 返回计算请求的种类名，用于机器可读的输出
+func Kind(e parser.EvalStmt) string {
+	switch e.(type) {
+	case *parser.EvalChangeBasis:
+		return "change_basis"
+	case *parser.EvalTransform:
+		return "transform"
+	case *parser.EvalCompose:
+		return "compose"
+	case *parser.EvalTransformMatrix:
+		return "transform_matrix"
+	case *parser.EvalTransition:
+		return "transition"
+	case *parser.EvalInner:
+		return "inner"
+	case *parser.EvalNorm:
+		return "norm"
+	case *parser.EvalAngle:
+		return "angle"
+	default:
+		return "unknown"
+	}
+}
+
+// combo 把线性组合写成 2 b1 - b2 的形式，系数优先使用精确值
+func combo(terms []parser.LinearTerm) string {
+	var sb strings.Builder
+	for _, t := range terms {
+		c := literal(t.Coeff, t.Exact)
+		neg := strings.HasPrefix(c, "-")
+		c = strings.TrimPrefix(c, "-")
+		switch {
+		case sb.Len() == 0 && neg:
+			sb.WriteString("-")
+		case sb.Len() > 0 && neg:
+			sb.WriteString(" - ")
+		case sb.Len() > 0:
+			sb.WriteString(" + ")
+		}
+		if c != "1" {
+			sb.WriteString(c + " ")
+		}
+		sb.WriteString(t.Vec)
+	}
+	return sb.String()
+}
+
+// literal 返回源代码中字面量的文本：有精确值时为约分后的分数，否则为浮点值的最短十进制表示
+func literal(f float64, r *big.Rat) string {
+	if r != nil {
+		return r.RatString()
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
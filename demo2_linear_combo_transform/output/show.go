@@ -0,0 +1,74 @@
+package output
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/btsyang/mathlang/parser"
+)
+
+// nameReplacer 把 \vec{b}_1、b_1 等写法化为 AST 中的名称 b1
+var nameReplacer = strings.NewReplacer(`\vec{`, "", "{", "", "}", "", "_", "")
+
+// Definition 返回名为 name 的全部定义的文本形式，向量、基和变换的名称可能相同，每个定义一行或多行，如
+//
+//	\vec{v} = (1 2)
+//	\vec{w} = 2 u - v
+//	b = {b1, b2}
+//	T: b -> c
+//	  T(b1) = 2 c1 + c2
+//
+// 参数：
+//
+//	ast: 抽象语法树
+//	name: 名称，向量可以写成 \vec{b}_1 或 b1，变换按源代码中的写法，如 T_1、\mathcal{T}
+//
+// 返回：
+//
+//	[]string: 各行文本，没有这个名称的定义时为空
+func Definition(ast *parser.AST, name string) []string {
+	raw := strings.Join(strings.Fields(name), "")
+	name = nameReplacer.Replace(raw)
+	var out []string
+	if v, ok := ast.Vecs[name]; ok {
+		var line string
+		if v.Expr != nil {
+			line = fmt.Sprintf("%s = %s", parser.VecText(v.Name), combo(v.Expr))
+		} else {
+			comps := make([]string, len(v.Comp))
+			for i, f := range v.Comp {
+				var r *big.Rat
+				if i < len(v.Exact) {
+					r = v.Exact[i]
+				}
+				comps[i] = literal(f, r)
+			}
+			line = fmt.Sprintf("%s = (%s)", parser.VecText(v.Name), strings.Join(comps, " "))
+		}
+		if v.Basis != nil {
+			line += fmt.Sprintf("  (in basis %s)", v.Basis.Name)
+		}
+		out = append(out, line)
+	}
+	if b, ok := ast.Bases[name]; ok {
+		names := make([]string, len(b.Vecs))
+		for i, v := range b.Vecs {
+			names[i] = v.Name
+		}
+		out = append(out, fmt.Sprintf("%s = {%s}", b.Name, strings.Join(names, ", ")))
+	}
+	if t, ok := ast.Transforms[raw]; ok {
+		out = append(out, fmt.Sprintf("%s: %s -> %s", t.Name, t.FromBasis.Name, t.ToBasis.Name))
+		keys := make([]string, 0, len(t.Map))
+		for k := range t.Map {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			out = append(out, fmt.Sprintf("  %s(%s) = %s", t.Name, k, combo(t.Map[k])))
+		}
+	}
+	return out
+}
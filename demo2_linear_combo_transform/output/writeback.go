@@ -0,0 +1,158 @@
+package output
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/btsyang/mathlang/calculator"
+)
+
+// ResultsKeyword 是写回的结果块的首行，之后是以 ": " 开头的定宽行，与 org-babel 的结果块写法一致
+const ResultsKeyword = "#+RESULTS: mathlang"
+
+// checkHeadingRe 匹配笔记中的 Check result 标题，如 ** Check result
+var checkHeadingRe = regexp.MustCompile(`^(\*+)\s+Check result\s*$`)
+
+// srcBeginRe 匹配 mathlang 源代码块的开始行，如 #+begin_src mathlang
+var srcBeginRe = regexp.MustCompile(`(?i)^#\+begin_src\s+mathlang(?:\s|$)`)
+
+// WriteBack 把计算结果写回源文件，除结果块以外的内容保持逐字节不变
+// 已有的结果块会被替换而不是重复追加，因此重复运行的结果相同；
+// 没有对应结果的结果块（计算请求已被删除、不再能解析，或被 -section、-skip-* 排除）会被删除
+// 参数：
+//
+//	src: 源文件内容
+//	results: 计算结果
+//	at: "statement" 把结果写在每个计算请求之后（所在的 \[ ... \] 之后），
+//	    "heading" 把所有结果写在 Check result 标题之下
+//
+// 返回：
+//
+//	[]byte: 写回后的文件内容
+//	error: 找不到 Check result 标题或 at 不合法时返回错误
+func WriteBack(src []byte, results []calculator.Result, at string) ([]byte, error) {
+	lines := strings.SplitAfter(string(src), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	// 插入点（下标为行号 - 1）→ 写在该行之后的结果
+	blocks := make(map[int][]string)
+	switch at {
+	case "statement":
+		// 计算请求写在显示公式块或源代码块中时，结果块放在块的结束定界符之后
+		ends := displayEnds(lines)
+		for _, r := range results {
+			i := ends[r.Line-1]
+			blocks[i] = append(blocks[i], resultLines(r)...)
+		}
+	case "heading":
+		h := -1
+		for i, line := range lines {
+			if checkHeadingRe.MatchString(strings.TrimRight(line, "\r\n")) {
+				h = i
+				break
+			}
+		}
+		if h < 0 {
+			return nil, fmt.Errorf("write-back: no \"Check result\" heading")
+		}
+		for _, r := range results {
+			blocks[h] = append(blocks[h], resultLines(r)...)
+		}
+	default:
+		return nil, fmt.Errorf("write-back: unknown position: %s", at)
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		sb.WriteString(line)
+		block, ok := blocks[i]
+		// 跳过旧的结果块；没有新结果的旧结果块被删除，不留下过时的值
+		if i+1 < len(lines) && strings.TrimRight(lines[i+1], "\r\n") == ResultsKeyword {
+			i++
+			for i+1 < len(lines) && strings.HasPrefix(lines[i+1], ":") {
+				i++
+			}
+		}
+		if !ok {
+			continue
+		}
+		// 结果块使用所在行的换行符；最后一行没有换行符时沿用文件第一行的
+		eol := "\n"
+		if strings.HasSuffix(line, "\r\n") || !strings.HasSuffix(line, "\n") && strings.HasSuffix(lines[0], "\r\n") {
+			eol = "\r\n"
+		}
+		if !strings.HasSuffix(line, "\n") {
+			sb.WriteString(eol)
+		}
+		sb.WriteString(ResultsKeyword + eol)
+		for _, l := range block {
+			sb.WriteString(l + eol)
+		}
+	}
+	return []byte(sb.String()), nil
+}
+
+// resultLines 返回一个计算结果在结果块中的各行，矩阵的每一行单独一行
+func resultLines(r calculator.Result) []string {
+	var out []string
+	switch {
+	case r.Err != nil:
+		out = []string{fmt.Sprintf("%s: error: %v", Label(r.Eval), r.Err)}
+	case r.Value.Kind == calculator.MatrixValue:
+		out = append([]string{Label(r.Eval) + " ="}, strings.Split(r.Value.String(), "\n")...)
+	default:
+		out = []string{fmt.Sprintf("%s = %s", Label(r.Eval), r.Value)}
+	}
+	for i, l := range out {
+		out[i] = ": " + l
+	}
+	return out
+}
+
+// displayEnds 返回每一行所在的 \[ ... \] 或 $$ ... $$ 显示公式块、mathlang 源代码块的最后一行的下标，
+// 不在块中的行为其自身
+func displayEnds(lines []string) []int {
+	ends := make([]int, len(lines))
+	for i := 0; i < len(lines); i++ {
+		ends[i] = i
+		line := strings.TrimSpace(lines[i])
+		if srcBeginRe.MatchString(line) {
+			j := i + 1
+			for j < len(lines) && !strings.EqualFold(strings.TrimSpace(lines[j]), "#+end_src") {
+				j++
+			}
+			if j == len(lines) {
+				continue
+			}
+			for k := i; k <= j; k++ {
+				ends[k] = j
+			}
+			i = j
+			continue
+		}
+		close := `\]`
+		if strings.HasPrefix(line, "$$") {
+			close = "$$"
+		} else if !strings.HasPrefix(line, `\[`) {
+			continue
+		}
+		// 结束定界符可能与开始定界符在同一行
+		j := i
+		if !strings.Contains(line[2:], close) {
+			for j = i + 1; j < len(lines) && !strings.Contains(lines[j], close); j++ {
+			}
+			if j == len(lines) {
+				continue
+			}
+		}
+		for k := i; k <= j; k++ {
+			ends[k] = j
+		}
+		i = j
+	}
+	return ends
+}
@@ -0,0 +1,181 @@
+package output
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/btsyang/mathlang/calculator"
+	"github.com/btsyang/mathlang/parser"
+)
+
+const wbBases = `* Note
+\vec{b}_1 = \begin{pmatrix}1\\0\end{pmatrix}
+\vec{b}_2 = \begin{pmatrix}0\\1\end{pmatrix}
+b = \{\vec{b}_1, \vec{b}_2\}
+\vec{v} = \begin{pmatrix}1\\2\end{pmatrix}
+`
+
+// writeBackTests 覆盖单独成行、显示公式块、源代码块中的计算请求和 heading 位置
+var writeBackTests = []struct {
+	name string
+	src  string
+	at   string
+}{
+	{"statement", wbBases + "[\\vec{v}]_b \\leftarrow \\text{eval}\nprose after\n", "statement"},
+	{"display", wbBases + "\\[\n\\|\\vec{v}\\| \\leftarrow \\text{eval} \\quad\n[\\vec{v}]_b \\leftarrow \\text{eval}\n\\]\nprose after\n", "statement"},
+	{"src block", wbBases + "#+begin_src mathlang\n[\\vec{v}]_b \\leftarrow \\text{eval}\n#+end_src\n", "statement"},
+	{"matrix", wbBases + "P_{b \\to b} \\leftarrow \\text{eval}\n", "statement"},
+	{"error", wbBases + "\\vec{c}_1 = \\vec{b}_1\n\\vec{c}_2 = 2\\vec{c}_1\nc = \\{\\vec{c}_1, \\vec{c}_2\\}\n[\\vec{v}]_c \\leftarrow \\text{eval}\n", "statement"},
+	{"last line", wbBases + "[\\vec{v}]_b \\leftarrow \\text{eval}", "statement"},
+	{"heading", wbBases + "[\\vec{v}]_b \\leftarrow \\text{eval}\n** Check result\ntext\n", "heading"},
+	{"stale block", wbBases + "[\\vec{v}]_b \\leftarrow \\text{eval}\n" + ResultsKeyword + "\n: [\\vec{v}]_b = (9 9)\n: old\nprose after\n", "statement"},
+}
+
+// writeBack 解析并计算 src，把结果写回
+func writeBack(t *testing.T, src, at string) string {
+	t.Helper()
+	ast, _ := parser.ParseAll("", strings.NewReader(src))
+	results, _ := calculator.Calculate(ast, calculator.Options{Exact: true})
+	out, err := WriteBack([]byte(src), results, at)
+	if err != nil {
+		t.Fatalf("WriteBack: %v", err)
+	}
+	return string(out)
+}
+
+// stripResults 删除全部结果块
+func stripResults(s string) string {
+	lines := strings.SplitAfter(s, "\n")
+	var sb strings.Builder
+	for i := 0; i < len(lines); i++ {
+		if strings.TrimRight(lines[i], "\r\n") == ResultsKeyword {
+			for i+1 < len(lines) && strings.HasPrefix(lines[i+1], ":") {
+				i++
+			}
+			continue
+		}
+		sb.WriteString(lines[i])
+	}
+	return sb.String()
+}
+
+func TestWriteBackStatement(t *testing.T) {
+	src := wbBases + "[\\vec{v}]_b \\leftarrow \\text{eval}\nprose after\n"
+	want := wbBases + "[\\vec{v}]_b \\leftarrow \\text{eval}\n" + ResultsKeyword + "\n: [\\vec{v}]_b = (1 2)\nprose after\n"
+	if got := writeBack(t, src, "statement"); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWriteBackIdempotent(t *testing.T) {
+	for _, tt := range writeBackTests {
+		t.Run(tt.name, func(t *testing.T) {
+			once := writeBack(t, tt.src, tt.at)
+			if twice := writeBack(t, once, tt.at); twice != once {
+				t.Errorf("second write-back changed the file:\n%s\nthen:\n%s", once, twice)
+			}
+		})
+	}
+}
+
+func TestWriteBackPreservesContent(t *testing.T) {
+	for _, tt := range writeBackTests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := writeBack(t, tt.src, tt.at)
+			if !strings.Contains(out, ResultsKeyword) {
+				t.Fatalf("no result block written:\n%s", out)
+			}
+			want := stripResults(tt.src)
+			if !strings.HasSuffix(want, "\n") {
+				// 结果块写在没有换行符的最后一行之后时，这一行补上换行符
+				want += "\n"
+			}
+			if got := stripResults(out); got != want {
+				t.Errorf("content outside result blocks changed:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestWriteBackCRLF(t *testing.T) {
+	for _, tt := range writeBackTests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := strings.ReplaceAll(tt.src, "\n", "\r\n")
+			out := writeBack(t, src, tt.at)
+			if n := strings.Count(out, "\n"); n != strings.Count(out, "\r\n") {
+				t.Errorf("output mixes line endings:\n%q", out)
+			}
+			if lf := writeBack(t, tt.src, tt.at); strings.ReplaceAll(out, "\r\n", "\n") != lf {
+				t.Errorf("CRLF output differs from LF output:\n%q\nwant:\n%q", out, lf)
+			}
+			if twice := writeBack(t, out, tt.at); twice != out {
+				t.Errorf("second write-back changed the file:\n%q\nthen:\n%q", out, twice)
+			}
+		})
+	}
+}
+
+func TestWriteBackNoHeading(t *testing.T) {
+	src := wbBases + "[\\vec{v}]_b \\leftarrow \\text{eval}\n"
+	ast, _ := parser.ParseAll("", strings.NewReader(src))
+	results, _ := calculator.Calculate(ast, calculator.Options{})
+	if _, err := WriteBack([]byte(src), results, "heading"); err == nil {
+		t.Error("expected an error without a Check result heading")
+	}
+	if _, err := WriteBack([]byte(src), results, "nowhere"); err == nil {
+		t.Error("expected an error for an unknown position")
+	}
+}
+
+// 没有新结果的旧结果块被删除
+func TestWriteBackStale(t *testing.T) {
+	old := ResultsKeyword + "\n: [\\vec{v}]_b = (9 9)\n"
+	tests := []struct {
+		name string
+		src  string
+		sel  *parser.Selection
+		at   string
+		want string
+	}{
+		{
+			"filtered by section",
+			wbBases + "[\\vec{v}]_b \\leftarrow \\text{eval}\n" + old + "* Other\n\\|\\vec{v}\\| \\leftarrow \\text{eval}\n",
+			&parser.Selection{Sections: []string{"Other"}},
+			"statement",
+			wbBases + "[\\vec{v}]_b \\leftarrow \\text{eval}\n* Other\n\\|\\vec{v}\\| \\leftarrow \\text{eval}\n" + ResultsKeyword + "\n: \\|\\vec{v}\\| = √5\n",
+		},
+		{
+			"no longer parses",
+			wbBases + "[\\vec{v}]_b \\leftarrow \\text{evaluate}\n" + old + "prose after\n",
+			nil,
+			"statement",
+			wbBases + "[\\vec{v}]_b \\leftarrow \\text{evaluate}\nprose after\n",
+		},
+		{
+			"eval deleted",
+			wbBases + old,
+			nil,
+			"statement",
+			wbBases,
+		},
+		{
+			"switched to heading",
+			wbBases + "[\\vec{v}]_b \\leftarrow \\text{eval}\n" + old + "** Check result\n",
+			nil,
+			"heading",
+			wbBases + "[\\vec{v}]_b \\leftarrow \\text{eval}\n** Check result\n" + ResultsKeyword + "\n: [\\vec{v}]_b = (1 2)\n",
+		},
+	}
+	for _, tt := range tests {
+		ast, _ := parser.ParseSelected("", strings.NewReader(tt.src), tt.sel)
+		results, _ := calculator.Calculate(ast, calculator.Options{Exact: true})
+		out, err := WriteBack([]byte(tt.src), results, tt.at)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if string(out) != tt.want {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", tt.name, out, tt.want)
+		}
+	}
+}
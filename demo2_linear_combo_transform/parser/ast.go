@@ -1,39 +1,53 @@
 package parser
 
+import "math/big"
+
 // AST 是抽象语法树的根节点，包含所有定义和计算请求
 type AST struct {
+	Headings   []*Heading                // org 笔记中的全部标题，按行号排列
 	Bases      map[string]*Basis         // 基的映射，键为基名
 	Vecs       map[string]*Vec           // 向量的映射，键为向量名
 	Transforms map[string]*TransformRule // 线性变换规则的映射，键为变换名
-	Eval       EvalStmt                  // 计算请求
+	Evals      []EvalStmt                // 计算请求，按在源文件中出现的顺序排列
 }
 
-// EvalStmt 是计算请求的接口，有两个实现：EvalChangeBasis 和 EvalTransform
+// EvalStmt 是计算请求的接口，实现有 EvalChangeBasis、EvalTransform、EvalCompose，
+// 求标量的 EvalInner、EvalNorm、EvalAngle，以及求矩阵的 EvalTransformMatrix、EvalTransition
 type EvalStmt interface {
-	evalKind() // 接口方法，用于类型断言
+	evalKind()       // 接口方法，用于类型断言
+	SourceLine() int // 计算请求所在的源代码行号
+	SourceCol() int  // 计算请求所在的源代码列号，从 1 开始
 }
 
 // Vec 表示一个向量，包含名称、基和分量
+// 向量可以由 pmatrix 字面量定义（Comp 非空），也可以由已定义向量的线性组合定义（Expr 非空），
+// 后者只记录表达式，分量由计算器求值
 type Vec struct {
-	Name  string    // 向量名称
-	Basis *Basis    // 向量所属的基
-	Comp  []float64 // 向量的分量
+	Name  string       // 向量名称
+	Basis *Basis       // 向量所属的基
+	Comp  []float64    // 向量的分量
+	Exact []*big.Rat   // 分量的精确值，与 Comp 一一对应
+	Expr  []LinearTerm // 定义向量的线性组合，如 2\vec{u} - \vec{v}
+	Pos   Pos          // 定义所在的源位置
 }
 
 // Basis 表示一个基，包含名称和向量列表
 type Basis struct {
 	Name string // 基的名称
 	Vecs []*Vec // 基中的向量列表，顺序即列序
+	Pos  Pos    // 定义所在的源位置
+
+	tok *Token // 定义所在的语句，用于计算器报告基不合法时定位，见 Errorf
 }
 
-// IndexOf 查找向量在基中的索引
+// IndexOf 查找向量在基中的索引，向量不在基中时返回 -1
 func (b *Basis) IndexOf(vecName string) int {
 	for i, v := range b.Vecs {
 		if v.Name == vecName {
 			return i
 		}
 	}
-	panic("vector " + vecName + " not found in basis " + b.Name)
+	return -1
 }
 
 // BasisEnv 是基的环境映射
@@ -41,8 +55,10 @@ type BasisEnv map[string]*Basis
 
 // LinearTerm 表示线性组合中的一项，包含系数和向量名
 type LinearTerm struct {
-	Coeff float64 // 系数
-	Vec   string  // 向量名（符号引用）
+	Coeff float64  // 系数
+	Exact *big.Rat // 系数的精确值
+	Vec   string   // 向量名（符号引用）
+	Ref   *Vec     // 已绑定的向量，仅用于向量表达式；变换规则中为 nil
 }
 
 // TransformRule 表示线性变换规则，包含名称、输入基、输出基和映射
@@ -51,21 +67,106 @@ type TransformRule struct {
 	FromBasis *Basis                  // 输入基
 	ToBasis   *Basis                  // 输出基
 	Map       map[string][]LinearTerm // 映射，键为输入基中的向量名，值为输出基中的线性组合
+	Pos       Pos                     // 第一条规则所在的源位置
 }
 
 // EvalChangeBasis 表示基变换计算请求
 type EvalChangeBasis struct {
 	Vec   *Vec   // 已绑定的向量
 	Basis *Basis // 已绑定的基
+	Line  int    // 源代码行号
+	Col   int    // 源代码列号
 }
 
-func (*EvalChangeBasis) evalKind() {}
+func (*EvalChangeBasis) evalKind()         {}
+func (e *EvalChangeBasis) SourceLine() int { return e.Line }
+func (e *EvalChangeBasis) SourceCol() int  { return e.Col }
 
 // EvalTransform 表示线性变换计算请求
 type EvalTransform struct {
 	Transform string         // 变换名称，如 "T"
 	Rule      *TransformRule // 已绑定的变换规则
 	Vec       *Vec           // 输入向量（在 FromBasis 下）
+	Line      int            // 源代码行号
+	Col       int            // 源代码列号
+}
+
+func (*EvalTransform) evalKind()         {}
+func (e *EvalTransform) SourceLine() int { return e.Line }
+func (e *EvalTransform) SourceCol() int  { return e.Col }
+
+// EvalCompose 表示复合变换计算请求，如 (S \circ T)(\vec{v})
+type EvalCompose struct {
+	Transforms []string         // 按书写顺序排列的变换名，最右边的最先作用
+	Rules      []*TransformRule // 已绑定的变换规则，与 Transforms 一一对应
+	Vec        *Vec             // 输入向量（在最右边变换的 FromBasis 下）
+	Line       int              // 源代码行号
+	Col        int              // 源代码列号
+}
+
+func (*EvalCompose) evalKind()         {}
+func (e *EvalCompose) SourceLine() int { return e.Line }
+func (e *EvalCompose) SourceCol() int  { return e.Col }
+
+// EvalInner 表示内积计算请求，如 \langle \vec{u}, \vec{v} \rangle
+type EvalInner struct {
+	U, V *Vec // 已绑定的两个向量
+	Line int  // 源代码行号
+	Col  int  // 源代码列号
+}
+
+func (*EvalInner) evalKind()         {}
+func (e *EvalInner) SourceLine() int { return e.Line }
+func (e *EvalInner) SourceCol() int  { return e.Col }
+
+// EvalNorm 表示范数计算请求，如 \|\vec{v}\|
+type EvalNorm struct {
+	Vec  *Vec // 已绑定的向量
+	Line int  // 源代码行号
+	Col  int  // 源代码列号
+}
+
+func (*EvalNorm) evalKind()         {}
+func (e *EvalNorm) SourceLine() int { return e.Line }
+func (e *EvalNorm) SourceCol() int  { return e.Col }
+
+// EvalAngle 表示夹角计算请求，如 \angle(\vec{u}, \vec{v})
+type EvalAngle struct {
+	U, V *Vec // 已绑定的两个向量
+	Line int  // 源代码行号
+	Col  int  // 源代码列号
+}
+
+func (*EvalAngle) evalKind()         {}
+func (e *EvalAngle) SourceLine() int { return e.Line }
+func (e *EvalAngle) SourceCol() int  { return e.Col }
+
+// EvalTransformMatrix 表示变换矩阵计算请求，如 [T]_{b}^{c}
+// 矩阵只是派生的输出，第 j 列是 T(b_j) 在 c 下的坐标
+type EvalTransformMatrix struct {
+	Transform string         // 变换名称
+	Rule      *TransformRule // 已绑定的变换规则
+	From      *Basis         // 输入基（下标）
+	To        *Basis         // 输出基（上标）
+	Line      int            // 源代码行号
+	Col       int            // 源代码列号
+}
+
+func (*EvalTransformMatrix) evalKind()         {}
+func (e *EvalTransformMatrix) SourceLine() int { return e.Line }
+func (e *EvalTransformMatrix) SourceCol() int  { return e.Col }
+
+// EvalTransition 表示过渡矩阵计算请求，如 P_{b \to c}
+// 矩阵的第 j 列是 b_j 在 c 下的坐标；Vec 非空时表示把矩阵作用在 Vec 上，
+// 即把 Vec 的分量视为 b 下的坐标，求它在 c 下的坐标
+type EvalTransition struct {
+	From *Basis // 原基 b
+	To   *Basis // 新基 c
+	Vec  *Vec   // 作用的向量，为 nil 时求矩阵本身
+	Line int    // 源代码行号
+	Col  int    // 源代码列号
 }
 
-func (*EvalTransform) evalKind() {}
+func (*EvalTransition) evalKind()         {}
+func (e *EvalTransition) SourceLine() int { return e.Line }
+func (e *EvalTransition) SourceCol() int  { return e.Col }
@@ -0,0 +1,174 @@
+package parser
+
+import (
+	"strings"
+	"unicode"
+)
+
+// fragment 是逻辑语句中来自同一物理行的一段，用于把语句中的偏移映射回源文件位置
+type fragment struct {
+	off  int    // 在语句文本中的起始偏移
+	line int    // 物理行号
+	col  int    // 在物理行中的起始字节偏移，从 0 开始
+	raw  string // 物理行原文
+}
+
+// blockLine 是显示公式块中的一行内容（已去掉 \[、\]、$$ 定界符）
+type blockLine struct {
+	text string // 内容
+	line int    // 物理行号
+	col  int    // 内容在物理行中的起始字节偏移
+	raw  string // 物理行原文
+}
+
+// statement 是从显示公式块中切分出的一条逻辑语句
+type statement struct {
+	text  string     // 语句文本，换行处拼接为一个空格
+	frags []fragment // 语句文本各段的来源
+}
+
+// displayOpen 返回显示公式块的开始定界符在 line 开头时的定界符与对应的结束定界符
+func displayOpen(line string) (open, close string, ok bool) {
+	switch {
+	case strings.HasPrefix(line, `\[`):
+		return `\[`, `\]`, true
+	case strings.HasPrefix(line, "$$"):
+		return "$$", "$$", true
+	default:
+		return "", "", false
+	}
+}
+
+// splitBlock 把显示公式块的内容拼接起来，再按语句切分
+// 在所有括号和 \begin ... \end 环境之外，以下位置是语句的分界：
+//   - \quad、\qquad 与 \\ 分隔符（前面的逗号等标点一并去掉）
+//   - 换行，除非上一行以 = + - , 结尾或下一行以 = + - 开头，此时视为同一语句的续行
+//
+// 因此一行一个分量的 pmatrix、跨行的线性组合都会被拼成一条语句
+// 参数：
+//
+//	lines: 块中的各行内容
+//
+// 返回：
+//
+//	[]statement: 切分出的语句，空语句被丢弃
+func splitBlock(lines []blockLine) []statement {
+	// 空行不影响语句的切分
+	kept := lines[:0:0]
+	for _, bl := range lines {
+		if strings.TrimSpace(bl.text) != "" {
+			kept = append(kept, bl)
+		}
+	}
+	lines = kept
+
+	// 拼接各行，starts[i] 是第 i 行在 text 中的起始偏移
+	var sb strings.Builder
+	starts := make([]int, len(lines))
+	for i, bl := range lines {
+		if i > 0 {
+			sb.WriteString(" ")
+		}
+		starts[i] = sb.Len()
+		sb.WriteString(bl.text)
+	}
+	text := sb.String()
+
+	// 行与行之间的拼接点是否是语句分界
+	breakAfter := make(map[int]bool) // 键为拼接空格在 text 中的偏移
+	for i := 0; i+1 < len(lines); i++ {
+		prev := strings.TrimRightFunc(lines[i].text, unicode.IsSpace)
+		next := strings.TrimLeftFunc(lines[i+1].text, unicode.IsSpace)
+		cont := strings.HasSuffix(prev, "=") || strings.HasSuffix(prev, "+") || strings.HasSuffix(prev, "-") || strings.HasSuffix(prev, ",") ||
+			strings.HasPrefix(next, "=") || strings.HasPrefix(next, "+") || strings.HasPrefix(next, "-")
+		breakAfter[starts[i+1]-1] = !cont
+	}
+
+	var out []statement
+	emit := func(a, b int) {
+		// 去掉首尾空白和分隔符前的标点
+		for a < b && unicode.IsSpace(rune(text[a])) {
+			a++
+		}
+		for a < b && strings.ContainsRune(" \t,.;", rune(text[b-1])) {
+			b--
+		}
+		if a >= b {
+			return
+		}
+		st := statement{text: text[a:b]}
+		for i, bl := range lines {
+			lo, hi := starts[i], starts[i]+len(bl.text)
+			if hi <= a || lo >= b {
+				continue
+			}
+			from := max(lo, a)
+			st.frags = append(st.frags, fragment{off: from - a, line: bl.line, col: bl.col + from - lo, raw: bl.raw})
+		}
+		out = append(out, st)
+	}
+
+	depth, env, start := 0, 0, 0
+	for i := 0; i < len(text); i++ {
+		rest := text[i:]
+		switch {
+		case strings.HasPrefix(rest, `\begin{`):
+			env++
+			i += len(`\begin`) - 1
+			continue
+		case strings.HasPrefix(rest, `\end{`):
+			env--
+			i += len(`\end`) - 1
+			continue
+		case strings.HasPrefix(rest, `\{`), strings.HasPrefix(rest, `\langle`):
+			depth++
+			i++
+			continue
+		case strings.HasPrefix(rest, `\}`), strings.HasPrefix(rest, `\rangle`):
+			depth--
+			i++
+			continue
+		case rest[0] == '{' || rest[0] == '(' || rest[0] == '[':
+			depth++
+			continue
+		case rest[0] == '}' || rest[0] == ')' || rest[0] == ']':
+			depth--
+			continue
+		}
+		if depth > 0 || env > 0 {
+			if rest[0] == '\\' {
+				i++ // 跳过转义字符，如 \\ 中的第二个反斜杠
+			}
+			continue
+		}
+		switch {
+		case strings.HasPrefix(rest, `\\`):
+			emit(start, i)
+			i++
+			start = i + 1
+		case isCommand(rest, `\quad`) || isCommand(rest, `\qquad`):
+			emit(start, i)
+			n := len(`\quad`)
+			if strings.HasPrefix(rest, `\qquad`) {
+				n = len(`\qquad`)
+			}
+			i += n - 1
+			start = i + 1
+		case breakAfter[i]:
+			emit(start, i)
+			start = i + 1
+		case rest[0] == '\\':
+			i++
+		}
+	}
+	emit(start, len(text))
+	return out
+}
+
+// isCommand 报告 s 是否以命令 cmd 开头，且命令名之后不是字母（\quad 不匹配 \quadrant）
+func isCommand(s, cmd string) bool {
+	if !strings.HasPrefix(s, cmd) {
+		return false
+	}
+	return len(s) == len(cmd) || !unicode.IsLetter(rune(s[len(cmd)]))
+}
@@ -0,0 +1,117 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+// blockLines 把块中的各行转为 blockLine，第 i 行的行号为 i+1
+func blockLines(lines []string) []blockLine {
+	out := make([]blockLine, len(lines))
+	for i, s := range lines {
+		out[i] = blockLine{text: s, line: i + 1, raw: s}
+	}
+	return out
+}
+
+func TestSplitBlock(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		want  []string
+	}{
+		{"one statement", []string{`\vec{v} = \begin{pmatrix}1\\2\end{pmatrix}`}, []string{`\vec{v} = \begin{pmatrix}1\\2\end{pmatrix}`}},
+		{
+			"quad separators",
+			[]string{`\vec{u} = \begin{pmatrix}1\\0\end{pmatrix}, \quad \vec{w} = \begin{pmatrix}0\\1\end{pmatrix} \qquad \|\vec{u}\| \leftarrow \text{eval}.`},
+			[]string{`\vec{u} = \begin{pmatrix}1\\0\end{pmatrix}`, `\vec{w} = \begin{pmatrix}0\\1\end{pmatrix}`, `\|\vec{u}\| \leftarrow \text{eval}`},
+		},
+		{
+			"row separators outside environments",
+			[]string{`T(\vec{b}_1) = \vec{b}_1 \\ T(\vec{b}_2) = 2\vec{b}_2 \\`},
+			[]string{`T(\vec{b}_1) = \vec{b}_1`, `T(\vec{b}_2) = 2\vec{b}_2`},
+		},
+		{
+			"pmatrix with one row per line",
+			[]string{`\vec{v} = \begin{pmatrix}`, `  1 \\`, `  2 \\`, `  3`, `\end{pmatrix}`},
+			[]string{`\vec{v} = \begin{pmatrix}   1 \\   2 \\   3 \end{pmatrix}`},
+		},
+		{
+			"continued linear combination",
+			[]string{`\vec{z} = \vec{u} +`, `2\vec{w}`, `- \vec{u}`, `\vec{y}`, `= 3\vec{u}`},
+			[]string{`\vec{z} = \vec{u} + 2\vec{w} - \vec{u}`, `\vec{y} = 3\vec{u}`},
+		},
+		{
+			"continued basis",
+			[]string{`b = \{\vec{b}_1,`, `\vec{b}_2\}`, `\langle \vec{u},`, `\vec{w} \rangle \leftarrow \text{eval}`},
+			[]string{`b = \{\vec{b}_1, \vec{b}_2\}`, `\langle \vec{u}, \vec{w} \rangle \leftarrow \text{eval}`},
+		},
+		{
+			"separators inside braces",
+			[]string{`b = \{\vec{b}_1, \quad \vec{b}_2\} \quad [\vec{u}]_{b} \leftarrow \text{eval}`},
+			[]string{`b = \{\vec{b}_1, \quad \vec{b}_2\}`, `[\vec{u}]_{b} \leftarrow \text{eval}`},
+		},
+		{"quadrant is not a separator", []string{`\quadrant`}, []string{`\quadrant`}},
+		{"one statement per line", []string{`\vec{u} = \vec{w}`, ``, `  `, `\|\vec{u}\| \leftarrow \text{eval}`}, []string{`\vec{u} = \vec{w}`, `\|\vec{u}\| \leftarrow \text{eval}`}},
+		{"empty", []string{``, ` \quad , `}, nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, st := range splitBlock(blockLines(tt.lines)) {
+			got = append(got, st.text)
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") || len(got) != len(tt.want) {
+			t.Errorf("%s:\n got %q\nwant %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// 从块中切分出的语句的诊断信息指向出错片段所在的物理行
+func TestDisplayBlockPositions(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		vecs int
+		want string // 唯一一条诊断信息的位置和消息
+	}{
+		{"error on a continuation line", "\\[\n\\vec{v} = \\begin{pmatrix}\n  1 \\\\\n  q\n\\end{pmatrix}\n\\]\n", 0, `4:3: invalid component value: "q"`},
+		{"error after a separator", "$$ \\vec{u} = \\begin{pmatrix}1\\\\2\\end{pmatrix} \\quad\n  \\vec{w} = \\vec{u} + \\vec{x} $$\n", 1, "2:23: vector expression uses undefined vector: x"},
+		{"error in an indented block", "  \\[ \\vec{u} = \\begin{pmatrix}1\\\\2\\end{pmatrix} \\quad [\\vec{u}]_c \\leftarrow \\text{eval} \\]\n", 1, "1:64: eval uses undefined basis: c"},
+		{"unterminated", "text\n  \\[ \\vec{u} = \\begin{pmatrix}1\\\\2\\end{pmatrix}\n", 0, `2:3: unterminated display math: missing \]`},
+		{"unterminated after several lines", "$$\n\\vec{u} = \\begin{pmatrix}1\\\\2\\end{pmatrix}\n", 0, "1:1: unterminated display math: missing $$"},
+	}
+	for _, tt := range tests {
+		ast, diags := ParseAll("", strings.NewReader(tt.src))
+		if len(diags) != 1 {
+			t.Errorf("%s: got %d diagnostics, want 1: %v", tt.name, len(diags), diags)
+			continue
+		}
+		if got := strings.TrimPrefix(diags[0].Error(), "<stdin>:"); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+		if len(ast.Vecs) != tt.vecs {
+			t.Errorf("%s: %d vectors, want %d", tt.name, len(ast.Vecs), tt.vecs)
+		}
+	}
+}
+
+func TestIncomplete(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{`\vec{v} = \begin{pmatrix}1\\2\end{pmatrix}`, false},
+		{`\[`, true},
+		{"\\[\n\\vec{v} = \\begin{pmatrix}1\\\\2\\end{pmatrix}", true},
+		{"\\[\n\\vec{v} = \\begin{pmatrix}1\\\\2\\end{pmatrix}\n\\]", false},
+		{`\[ \|\vec{v}\| \leftarrow \text{eval} \]`, false},
+		{"  $$ \\vec{v} = \\vec{u}", true},
+		{"$$ \\vec{v} = \\vec{u}\n$$", false},
+		{"$$\n$$\n\\[", true},
+	}
+	for _, tt := range tests {
+		if got := Incomplete(tt.text); got != tt.want {
+			t.Errorf("Incomplete(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Pos 表示源文件中的一个位置
+type Pos struct {
+	File string // 文件名，从标准输入读取时为空
+	Line int    // 行号，从 1 开始
+	Col  int    // 列号（按字节计），从 1 开始
+}
+
+// String 返回 file:line:col 形式的位置
+func (p Pos) String() string {
+	file := p.File
+	if file == "" {
+		file = "<stdin>"
+	}
+	return fmt.Sprintf("%s:%d:%d", file, p.Line, p.Col)
+}
+
+// Severity 表示诊断信息的严重程度
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+// String 返回 "error" 或 "warning"
+func (s Severity) String() string {
+	if s == SeverityWarning {
+		return "warning"
+	}
+	return "error"
+}
+
+// Diagnostic 是带源位置的诊断信息，实现 error 接口
+type Diagnostic struct {
+	Severity Severity // 严重程度，零值为错误
+	Pos      Pos      // 出错片段的起始位置
+	EndCol   int      // 出错片段的结束列（不含），与 Pos 在同一行
+	Msg      string   // 诊断消息
+	Source   string   // 出错片段所在的源代码行
+	Heading  *Heading // 出错语句所在的 org 标题，可能为 nil
+
+	cascade bool   // 由前面失败的定义引起的级联错误，容错模式下不报告
+	stmt    string // 出错的完整语句，跨行语句的 Source 只是其中一行
+}
+
+// Diagnostics 是按出现顺序排列的一组诊断信息
+type Diagnostics []*Diagnostic
+
+// HasErrors 报告其中是否有错误级别的诊断信息
+func (ds Diagnostics) HasErrors() bool {
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Sort 按源位置稳定排序，用于把计算器检查基得到的诊断信息与语法分析的诊断信息合并
+func (ds Diagnostics) Sort() {
+	sort.SliceStable(ds, func(i, j int) bool {
+		a, b := ds[i].Pos, ds[j].Pos
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Col < b.Col
+	})
+}
+
+// Error 返回 file:line:col: msg 形式的单行描述
+func (d *Diagnostic) Error() string {
+	return fmt.Sprintf("%s: %s", d.Pos, d.Msg)
+}
+
+// Format 按编译器风格渲染诊断信息：位置与消息、出错的源代码行，以及出错片段下方的脱字符
+//
+//	notes.org: in section "Given bases":
+//	notes.org:12:17: error: basis uses undefined vector: b3
+//	   12 | b = \{\vec{b}_1,\vec{b}_3\}
+//	      |                 ^^^^^^^^^
+func (d *Diagnostic) Format() string {
+	var sb strings.Builder
+	if d.Heading != nil {
+		file := d.Pos.File
+		if file == "" {
+			file = "<stdin>"
+		}
+		fmt.Fprintf(&sb, "%s: in section \"%s\":\n", file, d.Heading.Path())
+	}
+	fmt.Fprintf(&sb, "%s: %s: %s\n", d.Pos, d.Severity, d.Msg)
+	if d.Source == "" {
+		return sb.String()
+	}
+	gutter := fmt.Sprintf("%5d", d.Pos.Line)
+	fmt.Fprintf(&sb, "%s | %s\n", gutter, d.Source)
+
+	start := clamp(d.Pos.Col-1, 0, len(d.Source))
+	end := clamp(d.EndCol-1, start, len(d.Source))
+	// 缩进部分保留源代码中的制表符，使脱字符在任意制表宽度下都能对齐
+	pad := []rune(d.Source[:start])
+	for i, r := range pad {
+		if r != '\t' {
+			pad[i] = ' '
+		}
+	}
+	width := utf8.RuneCountInString(d.Source[start:end])
+	if width == 0 {
+		width = 1
+	}
+	fmt.Fprintf(&sb, "%s | %s%s\n", strings.Repeat(" ", len(gutter)), string(pad), strings.Repeat("^", width))
+	return sb.String()
+}
+
+// clamp 把 x 限制在 [lo, hi] 区间内
+func clamp(x, lo, hi int) int {
+	if x < lo {
+		return lo
+	}
+	if x > hi {
+		return hi
+	}
+	return x
+}
+
+// errorAt 构造一个指向 token 源代码行中 [start, end) 字节区间的诊断信息
+// 显示公式块中跨行的语句，区间被映射回 start 所在的物理行，并截断到该行末尾
+// 参数：
+//
+//	start, end: 出错片段在 Text 中的字节偏移
+//	format, args: 诊断消息
+//
+// 返回：
+//
+//	*Diagnostic: 诊断信息
+func (t *Token) errorAt(start, end int, format string, args ...any) *Diagnostic {
+	d := &Diagnostic{
+		Pos:     Pos{File: t.Pos.File, Line: t.Pos.Line, Col: start + 1},
+		EndCol:  end + 1,
+		Msg:     fmt.Sprintf(format, args...),
+		Source:  t.Text,
+		Heading: t.Heading,
+		stmt:    t.Text,
+	}
+	if len(t.frags) == 0 {
+		return d
+	}
+	// 找到 start 所在的片段，片段之间以一个空格拼接
+	i := len(t.frags) - 1
+	for i > 0 && t.frags[i].off > start {
+		i--
+	}
+	f := t.frags[i]
+	fragEnd := len(t.Text)
+	if i+1 < len(t.frags) {
+		fragEnd = t.frags[i+1].off - 1
+	}
+	d.Pos.Line = f.line
+	d.Pos.Col = f.col + max(start-f.off, 0) + 1
+	d.EndCol = f.col + min(end, fragEnd) - f.off + 1
+	d.Source = f.raw
+	return d
+}
+
+// start 返回语句在 Text 中的起始偏移：显示公式块中的语句从 0 开始，单独成行的语句从缩进之后开始
+func (t *Token) start() int {
+	if t.frags != nil {
+		return 0
+	}
+	return t.Pos.Col - 1
+}
+
+// errorf 构造一个指向 token 中某个片段的诊断信息
+// 参数：
+//
+//	sub: 出错片段的原文，在语句中查找它的第一次出现；为空或找不到时指向整条语句
+//	format, args: 诊断消息
+//
+// 返回：
+//
+//	*Diagnostic: 诊断信息
+func (t *Token) errorf(sub string, format string, args ...any) *Diagnostic {
+	stmt := t.start()
+	if i := strings.Index(t.Text[stmt:], sub); sub != "" && i >= 0 {
+		return t.errorAt(stmt+i, stmt+i+len(sub), format, args...)
+	}
+	return t.errorAt(stmt, len(strings.TrimRightFunc(t.Text, unicode.IsSpace)), format, args...)
+}
+
+// Errorf 构造一个指向基的定义中某个片段的诊断信息，供语法分析之后的阶段定位到定义处
+// 参数：
+//
+//	sub: 出错片段的原文，含义同 Token.errorf
+//	format, args: 诊断消息
+//
+// 返回：
+//
+//	*Diagnostic: 诊断信息
+func (b *Basis) Errorf(sub string, format string, args ...any) *Diagnostic {
+	return b.tok.errorf(sub, format, args...)
+}
@@ -0,0 +1,283 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// evalSuffix 是计算请求的规范结尾
+const evalSuffix = ` \leftarrow \text{eval}`
+
+// edit 是对源文件中一行的 [start, end) 字节区间的替换
+type edit struct {
+	line       int
+	start, end int
+	text       string
+}
+
+// Format 把源文件中能识别的语句改写为规范的写法，语句之外的笔记内容原样保留
+// 规范写法：运算符两侧各一个空格，系数与向量之间没有空格，省略系数 1，
+// 基写作 \{\vec{b}_1, \vec{b}_2\}，计算请求以 \leftarrow \text{eval} 结尾
+// 跨多行的语句、含有规范写法无法表示的内容的语句保持原样；结果再次格式化不会改变
+// 参数：
+//
+//	src: 源文件内容
+//
+// 返回：
+//
+//	[]byte: 格式化后的内容
+//	error: 格式化改变了抽象语法树时返回错误，此时不应使用结果
+func Format(src []byte) ([]byte, error) {
+	var edits []edit
+	l := NewLexer(bytes.NewReader(src))
+	for {
+		tok, err := l.Next()
+		if err != nil {
+			// 有错误的语句保持原样
+			continue
+		}
+		if tok == nil {
+			break
+		}
+		line, start, end, ok := tok.span()
+		if !ok {
+			continue
+		}
+		text, ok := printStmt(tok)
+		if !ok || text == tok.stmt() || squash(text) != squash(tok.stmt()) {
+			continue
+		}
+		edits = append(edits, edit{line: line, start: start, end: end, text: text})
+	}
+
+	// 从后往前替换，前面的偏移不受影响
+	lines := strings.SplitAfter(string(src), "\n")
+	sort.Slice(edits, func(i, j int) bool {
+		if edits[i].line != edits[j].line {
+			return edits[i].line > edits[j].line
+		}
+		return edits[i].start > edits[j].start
+	})
+	for _, e := range edits {
+		s := lines[e.line-1]
+		lines[e.line-1] = s[:e.start] + e.text + s[e.end:]
+	}
+	out := []byte(strings.Join(lines, ""))
+
+	// 格式化只改变写法：两次解析得到的抽象语法树和诊断信息必须相同
+	before, bdiags := ParseAll("", bytes.NewReader(src))
+	after, adiags := ParseAll("", bytes.NewReader(out))
+	if fingerprint(before, bdiags) != fingerprint(after, adiags) {
+		return nil, errors.New("fmt: formatting would change the meaning of the note")
+	}
+	return out, nil
+}
+
+// stmt 返回 token 对应的语句文本（去除首尾空白）
+func (t *Token) stmt() string {
+	return strings.TrimSpace(t.Text[t.start():])
+}
+
+// span 返回语句在源文件中的位置，只有整条语句在同一物理行中时才能定位
+// 返回：
+//
+//	line: 行号
+//	start, end: 语句在该行中的字节区间
+//	ok: 语句跨多行，或与同一行中的其它内容无法区分时为 false
+func (t *Token) span() (line, start, end int, ok bool) {
+	switch len(t.frags) {
+	case 0:
+		// 单独成行的语句：整行是一条语句，行中还有其它语句（如以 \quad 分隔）时无法单独改写
+		sts := splitBlock([]blockLine{{text: t.Text, line: t.Pos.Line}})
+		if len(sts) != 1 || sts[0].text != t.stmt() {
+			return 0, 0, 0, false
+		}
+		start = t.Pos.Col - 1
+		return t.Pos.Line, start, start + len(t.stmt()), true
+	case 1:
+		f := t.frags[0]
+		return f.line, f.col, f.col + len(t.Text), true
+	default:
+		return 0, 0, 0, false
+	}
+}
+
+// printStmt 返回语句的规范写法
+// 参数：
+//
+//	tok: 词法分析得到的 token
+//
+// 返回：
+//
+//	string: 规范写法
+//	bool: 是否支持这种语句
+func printStmt(tok *Token) (string, bool) {
+	switch a := tok.Args.(type) {
+	case *VecAssignArgs:
+		comps := make([]string, len(a.Raw))
+		for i, c := range a.Raw {
+			comps[i] = strings.Join(strings.Fields(c), "")
+		}
+		return VecText(a.Name) + ` = \begin{pmatrix}` + strings.Join(comps, `\\`) + `\end{pmatrix}`, true
+	case *VecComboArgs:
+		return VecText(a.Name) + " = " + printCombo(a.RawTerms), true
+	case *TransformAssignArgs:
+		return a.Transform + "(" + VecText(a.DomainVec[0]+a.DomainVec[1]) + ") = " + printCombo(a.RawTerms), true
+	case *BasisAssignArgs:
+		vecs := make([]string, len(a.Vecs))
+		for i, v := range a.Vecs {
+			vecs[i] = VecText(v)
+		}
+		return a.Name + ` = \{` + strings.Join(vecs, ", ") + `\}`, true
+	case *EvalChangeBasisArgs:
+		return "[" + VecText(a.Vec) + "]_" + a.Basis + evalSuffix, true
+	case *EvalTransformArgs:
+		return a.Transform + "(" + VecText(a.VecName) + ")" + evalSuffix, true
+	case *EvalComposeArgs:
+		return "(" + strings.Join(a.Transforms, ` \circ `) + ")(" + VecText(a.VecName) + ")" + evalSuffix, true
+	case *EvalTransformMatrixArgs:
+		text := "[" + a.Transform + "]_{" + a.From + "}"
+		if a.To != a.From {
+			text += "^{" + a.To + "}"
+		}
+		return text + evalSuffix, true
+	case *EvalTransitionArgs:
+		text := `P_{` + a.From + ` \to ` + a.To + `}`
+		if len(a.Vecs) > 0 {
+			vecs := make([]string, len(a.Vecs))
+			for i, v := range a.Vecs {
+				vecs[i] = VecText(v)
+			}
+			text += "(" + strings.Join(vecs, ", ") + ")"
+		}
+		return text + evalSuffix, true
+	case *EvalInnerArgs:
+		return `\langle ` + VecText(a.U) + ", " + VecText(a.V) + ` \rangle` + evalSuffix, true
+	case *EvalNormArgs:
+		return `\|` + VecText(a.VecName) + `\|` + evalSuffix, true
+	case *EvalAngleArgs:
+		return `\angle(` + VecText(a.U) + ", " + VecText(a.V) + ")" + evalSuffix, true
+	default:
+		return "", false
+	}
+}
+
+// printCombo 返回线性组合的规范写法，如 2\vec{c}_1 - \vec{c}_2
+// 系数保留源代码中的字面量，只去掉空白；系数为 1 时省略
+func printCombo(raw [][]string) string {
+	var sb strings.Builder
+	for i, t := range raw {
+		coeff := strings.Join(strings.Fields(t[1]), "")
+		neg := strings.HasPrefix(coeff, "-")
+		coeff = strings.TrimLeft(coeff, "+-")
+		if coeff == "1" {
+			coeff = ""
+		}
+		switch {
+		case i == 0 && neg:
+			sb.WriteString("-")
+		case i > 0 && neg:
+			sb.WriteString(" - ")
+		case i > 0:
+			sb.WriteString(" + ")
+		}
+		sb.WriteString(coeff + `\vec{` + t[2] + `}`)
+		if t[3] != "" {
+			sb.WriteString("_" + t[3])
+		}
+	}
+	return sb.String()
+}
+
+var (
+	spaceRe      = regexp.MustCompile(`\s+`)
+	braceIndexRe = regexp.MustCompile(`([_^])\{([a-zA-Z0-9])\}`)
+	unitCoeffRe  = regexp.MustCompile(`(^|[=+\-,(])\+?1(\\vec\{)`)
+	leadPlusRe   = regexp.MustCompile(`=\+`)
+)
+
+// squash 把语句化为与写法无关的形式：去掉空白、单字符下标的花括号、系数 1 和多余的正号，
+// 统一 \rightarrow 与 \to、\lVert \rVert 与 \|
+// 格式化前后的语句在这个形式下必须相同，保证格式化不会丢掉语句中任何有意义的内容
+func squash(s string) string {
+	s = spaceRe.ReplaceAllString(s, "")
+	s = strings.NewReplacer(`\rightarrow`, `\to`, `\lVert`, `\|`, `\rVert`, `\|`).Replace(s)
+	s = braceIndexRe.ReplaceAllString(s, "$1$2")
+	s = unitCoeffRe.ReplaceAllString(s, "$1$2")
+	return leadPlusRe.ReplaceAllString(s, "=")
+}
+
+// fingerprint 返回抽象语法树和诊断信息的文本摘要，用于检查格式化前后语义是否相同
+// 行号和列号不计入摘要
+func fingerprint(ast *AST, diags Diagnostics) string {
+	var lines []string
+	for _, v := range ast.Vecs {
+		line := "vec " + v.Name
+		for _, r := range v.Exact {
+			line += " " + r.RatString()
+		}
+		line += " " + termsText(v.Expr)
+		lines = append(lines, line)
+	}
+	for _, b := range ast.Bases {
+		line := "basis " + b.Name
+		for _, v := range b.Vecs {
+			line += " " + v.Name
+		}
+		lines = append(lines, line)
+	}
+	for _, t := range ast.Transforms {
+		for k, terms := range t.Map {
+			lines = append(lines, fmt.Sprintf("transform %s %s %s %s: %s", t.Name, t.FromBasis.Name, t.ToBasis.Name, k, termsText(terms)))
+		}
+	}
+	sort.Strings(lines)
+	for _, e := range ast.Evals {
+		lines = append(lines, fmt.Sprintf("eval %T %s", e, evalText(e)))
+	}
+	for _, d := range diags {
+		lines = append(lines, "diag "+d.Msg)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// termsText 返回线性组合的摘要
+func termsText(terms []LinearTerm) string {
+	var sb strings.Builder
+	for _, t := range terms {
+		fmt.Fprintf(&sb, " %s*%s", t.Exact.RatString(), t.Vec)
+	}
+	return sb.String()
+}
+
+// evalText 返回计算请求引用的符号
+func evalText(e EvalStmt) string {
+	switch e := e.(type) {
+	case *EvalChangeBasis:
+		return e.Vec.Name + " " + e.Basis.Name
+	case *EvalTransform:
+		return e.Transform + " " + e.Vec.Name
+	case *EvalCompose:
+		return strings.Join(e.Transforms, " ") + " " + e.Vec.Name
+	case *EvalTransformMatrix:
+		return e.Transform + " " + e.From.Name + " " + e.To.Name
+	case *EvalTransition:
+		text := e.From.Name + " " + e.To.Name
+		if e.Vec != nil {
+			text += " " + e.Vec.Name
+		}
+		return text
+	case *EvalInner:
+		return e.U.Name + " " + e.V.Name
+	case *EvalNorm:
+		return e.Vec.Name
+	case *EvalAngle:
+		return e.U.Name + " " + e.V.Name
+	default:
+		return ""
+	}
+}
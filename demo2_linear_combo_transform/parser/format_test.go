@@ -0,0 +1,143 @@
+package parser
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// formatTests 是单独成行的语句及其规范写法，want 为空表示保持原样
+var formatTests = []struct {
+	in   string
+	want string
+}{
+	{`\vec{u}=\begin{pmatrix} 1 \\ - 2\\\frac {1}{2} \end{pmatrix}`, `\vec{u} = \begin{pmatrix}1\\-2\\\frac{1}{2}\end{pmatrix}`},
+	{`\vec{b}_1 = \begin{pmatrix}1\\0\end{pmatrix}`, ""},
+	{`\vec{z} =  1\vec{u}+ 2 \vec{w} -1\vec{u}`, `\vec{z} = \vec{u} + 2\vec{w} - \vec{u}`},
+	{`\vec{z} = -1\vec{u} + 1/2\vec{w}`, `\vec{z} = -\vec{u} + 1/2\vec{w}`},
+	{`b=\{ \vec{b}_1 ,\vec{b}_2 \}`, `b = \{\vec{b}_1, \vec{b}_2\}`},
+	{`T( \vec{b}_1 ) = 1\vec{c}_1 + 1\vec{c}_2`, `T(\vec{b}_1) = \vec{c}_1 + \vec{c}_2`},
+	{`[\vec{u} ]_ b \leftarrow\text{eval}`, `[\vec{u}]_b \leftarrow \text{eval}`},
+	{`[ \vec{u} ]_b \leftarrow \text{eval}`, `[\vec{u}]_b \leftarrow \text{eval}`},
+	{`T( \vec{u} )\leftarrow \text{eval}`, `T(\vec{u}) \leftarrow \text{eval}`},
+	{`(S\circ T)(\vec{u}) \leftarrow \text{eval}`, `(S \circ T)(\vec{u}) \leftarrow \text{eval}`},
+	{`[T]_{ b } \leftarrow \text{eval}`, `[T]_{b} \leftarrow \text{eval}`},
+	// 省略上标会改变写法之外的内容，保持原样
+	{`[T]_b^{b} \leftarrow \text{eval}`, ""},
+	{`[T]_{b}^{c} \leftarrow \text{eval}`, ""},
+	{`P_{ b \rightarrow c }( \vec{u},\vec{w} ) \leftarrow \text{eval}`, `P_{b \to c}(\vec{u}, \vec{w}) \leftarrow \text{eval}`},
+	{`\langle\vec{u},\vec{w}\rangle \leftarrow \text{eval}`, `\langle \vec{u}, \vec{w} \rangle \leftarrow \text{eval}`},
+	{`\lVert\vec{u}\rVert \leftarrow \text{eval}`, `\|\vec{u}\| \leftarrow \text{eval}`},
+	{`\angle (\vec{u} ,\vec{w}) \leftarrow \text{eval}`, `\angle(\vec{u}, \vec{w}) \leftarrow \text{eval}`},
+	// 不是语句或有错误的行保持原样
+	{`Some prose about vectors.`, ""},
+	{`\vec{v} = \begin{pmatrix}1\\x\end{pmatrix}`, ""},
+	{`\vec{v} = \begin{pmatrix}1\\2\end{pmatrix} \quad \vec{w} = \begin{pmatrix}3\\4\end{pmatrix}`, ""},
+}
+
+func TestFormatStatements(t *testing.T) {
+	for _, tt := range formatTests {
+		want := tt.want
+		if want == "" {
+			want = tt.in
+		}
+		// 缩进和语句之后的换行保持原样
+		got, err := Format([]byte("  " + tt.in + "\n"))
+		if err != nil {
+			t.Errorf("Format(%q) error: %v", tt.in, err)
+			continue
+		}
+		if string(got) != "  "+want+"\n" {
+			t.Errorf("Format(%q)\n got: %q\nwant: %q", tt.in, got, "  "+want+"\n")
+		}
+	}
+}
+
+// formatNote 是一篇含有正文、标题、显示公式块和跨多行语句的笔记
+const formatNote = `* Fmt
+Some prose with words.
+\[
+\vec{u}=\begin{pmatrix} 1 \\ - 2\\\frac {1}{2} \end{pmatrix} ,\quad \vec{w}  =  \begin{pmatrix}0.5\\1/3\\4\end{pmatrix}
+\]
+\vec{z} =  1\vec{u}+ 2 \vec{w} -1\vec{u}
+** Bases
+\vec{b}_1 = \begin{pmatrix}1\\0\\0\end{pmatrix}
+\vec{b}_2 = \begin{pmatrix}0\\1\\0\end{pmatrix}
+\vec{b}_3 = \begin{pmatrix}0\\0\\1\end{pmatrix}
+b=\{ \vec{b}_1 ,\vec{b}_2, \vec{b}_3 \}
+\[ T( \vec{b}_1 ) = 1\vec{b}_1 \quad T(\vec{b}_2)=2\vec{b}_2 \quad T(\vec{b}_3) = -\vec{b}_3 \]
+\[ [\vec{u} ]_ b \leftarrow\text{eval} \qquad \| \vec{u} \|\leftarrow   \text{eval} \]
+\[
+\vec{m} = \begin{pmatrix}
+  1 \\ 2 \\ 3
+\end{pmatrix}
+\]
+T(\vec{m}) \leftarrow \text{eval}
+`
+
+func TestFormatIdempotent(t *testing.T) {
+	inputs := []string{formatNote, strings.ReplaceAll(formatNote, "\n", "\r\n")}
+	for _, tt := range formatTests {
+		inputs = append(inputs, tt.in+"\n")
+	}
+	for _, in := range inputs {
+		once, err := Format([]byte(in))
+		if err != nil {
+			t.Errorf("Format(%q) error: %v", in, err)
+			continue
+		}
+		twice, err := Format(once)
+		if err != nil {
+			t.Errorf("Format(%q) error: %v", once, err)
+			continue
+		}
+		if !bytes.Equal(once, twice) {
+			t.Errorf("Format is not idempotent:\n%s\nthen:\n%s", once, twice)
+		}
+	}
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	for _, in := range []string{formatNote, strings.ReplaceAll(formatNote, "\n", "\r\n")} {
+		out, err := Format([]byte(in))
+		if err != nil {
+			t.Fatalf("Format error: %v", err)
+		}
+		if string(out) == in {
+			t.Fatal("Format did not rewrite any statement")
+		}
+		before, bdiags := ParseAll("", strings.NewReader(in))
+		after, adiags := ParseAll("", strings.NewReader(string(out)))
+		if b, a := fingerprint(before, bdiags), fingerprint(after, adiags); b != a {
+			t.Errorf("formatting changed the AST:\n%s\nthen:\n%s", b, a)
+		}
+		if len(before.Evals) != 3 || len(before.Vecs) != 7 {
+			t.Errorf("note parsed to %d vectors and %d evals, want 7 and 3", len(before.Vecs), len(before.Evals))
+		}
+	}
+}
+
+func TestFormatPreservesOtherLines(t *testing.T) {
+	out, err := Format([]byte(formatNote))
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := strings.Split(formatNote, "\n")
+	got := strings.Split(string(out), "\n")
+	if len(in) != len(got) {
+		t.Fatalf("Format changed the number of lines: %d, want %d", len(got), len(in))
+	}
+	// 正文、标题、定界符和跨多行的语句逐字节不变
+	for _, i := range []int{0, 1, 2, 4, 6, 13, 14, 15, 16, 17} {
+		if in[i] != got[i] {
+			t.Errorf("line %d changed: %q, want %q", i+1, got[i], in[i])
+		}
+	}
+	crlf, err := Format([]byte(strings.ReplaceAll(formatNote, "\n", "\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.ReplaceAll(string(out), "\n", "\r\n"); string(crlf) != want {
+		t.Errorf("CRLF note formatted differently:\n%q\nwant:\n%q", crlf, want)
+	}
+}
@@ -2,23 +2,34 @@ package parser
 
 import (
 	"bufio"
-	"fmt"
-
-	// "fmt"
 	"io"
+	"math/big"
 	"regexp"
-	"strconv"
 	"strings"
+	"unicode"
 )
 
 type Token struct {
 	Kind string
 	Args any
+	Pos  Pos    // 语句在源文件中的起始位置
+	Text string // 语句文本：单独成行的语句为所在的源代码行（未去除首尾空白），显示公式块中的语句为拼接后的逻辑语句
+
+	Heading *Heading // 语句所在的 org 标题，第一个标题之前为 nil
+
+	frags []fragment // 显示公式块中的语句各段的来源，用于把诊断信息定位回物理行
 }
 
 type VecAssignArgs struct {
-	Name string
-	Comp []float64
+	Name  string
+	Comp  []float64
+	Exact []*big.Rat
+	Raw   []string // 分量在源代码中的写法（去除首尾空白），用于格式化时保留原来的字面量
+}
+
+type VecComboArgs struct {
+	Name     string
+	RawTerms [][]string // 每一项的子匹配：[全文, 系数, 向量字母, 下标]
 }
 
 type BasisAssignArgs struct {
@@ -38,26 +49,73 @@ type EvalChangeBasisArgs struct {
 // }
 
 type TransformAssignArgs struct {
-	Transform string   // "T"
+	Transform string   // "T"、"S"、"T_1"、"\mathcal{T}"
 	DomainVec []string // "b2"
 	RawTerms  [][]string
+	TermSpans [][2]int // 每一项中的向量在 Token.Text 中的字节区间，用于诊断信息定位
 }
 
 type EvalTransformArgs struct {
-	Transform string // "T"
+	Transform string // "T"、"S"、"T_1"、"\mathcal{T}"
 	VecName   string
 	// ToBasis   string 如果在规则部分定义 输入基和输出基， 这里先删掉，表达式里目前没有这个部分
 }
 
 // func (*EvalTransform) evalKind() {}
 
+type EvalInnerArgs struct {
+	U, V string // \langle \vec{u}, \vec{v} \rangle
+}
+
+type EvalNormArgs struct {
+	VecName string // \|\vec{v}\|
+}
+
+type EvalAngleArgs struct {
+	U, V string // \angle(\vec{u}, \vec{v})
+}
+
+type EvalTransformMatrixArgs struct {
+	Transform string // [T]_{b}^{c} 中的 T
+	From      string // 输入基 b
+	To        string // 输出基 c，省略上标时与 From 相同
+}
+
+type EvalTransitionArgs struct {
+	From string   // P_{b \to c} 中的 b
+	To   string   // P_{b \to c} 中的 c
+	Vecs []string // 作用的向量，如 P_{b \to c}(\vec{u}, \vec{v})；为空时求矩阵本身
+}
+
+type EvalComposeArgs struct {
+	Transforms []string // 按书写顺序排列的变换名，如 ["S", "T"] 表示 S \circ T
+	VecName    string
+}
+
 type Lexer struct {
 	scanner           *bufio.Scanner
+	file              string // 文件名，用于诊断信息
+	line              int    // 已读取的行数
+	org               orgState
+	strict            bool        // 严格模式：只解释 mathlang 源代码块和带有指令的显示公式块
+	fence             fenceState  // 严格模式下所在的源代码块和指令
+	pending           []*Token    // 已读取但尚未返回的语句，一个显示公式块可能包含多条语句
+	deferred          *Diagnostic // 上一个 token 附带的警告，在下一次调用 Next 时返回
 	vecAssignRe       *regexp.Regexp
 	basisAssignRe     *regexp.Regexp
 	evalChangeBasisRe *regexp.Regexp
 	transformAssignRe *regexp.Regexp
+	vecComboRe        *regexp.Regexp
+	transformHeadRe   *regexp.Regexp
 	evalTransformRe   *regexp.Regexp
+	evalComposeRe     *regexp.Regexp
+	evalInnerRe       *regexp.Regexp
+	evalMatrixRe      *regexp.Regexp
+	evalNormRe        *regexp.Regexp
+	evalAngleRe       *regexp.Regexp
+	evalTransitionRe  *regexp.Regexp
+	vecRefRe          *regexp.Regexp
+	circRe            *regexp.Regexp
 	termRe            *regexp.Regexp
 }
 
@@ -70,17 +128,79 @@ const (
 	StmtTransformAssign
 	StmtEvalChangeBasis
 	StmtEvalTransform
+	StmtEvalCompose
+	StmtVecCombo
+	StmtEvalInner
+	StmtEvalNorm
+	StmtEvalAngle
+	StmtEvalTransformMatrix
+	StmtEvalTransition
 )
 
+// vecRefPattern 匹配一个向量引用，如 \vec{v}、\vec{b}_1，两个捕获组分别为字母和下标
+const vecRefPattern = `\\vec\{([a-zA-Z]+)\}(?:_([0-9]+))?`
+
+// basisIndexPattern 匹配下标或上标位置的基名，如 b 或 {b}，两个捕获组择一非空
+const basisIndexPattern = `(?:\{\s*([a-zA-Z]+)\s*\}|([a-zA-Z]))`
+
+// matrixLineRe 识别变换矩阵的写法 [T]_{b}^{c}
+var matrixLineRe = regexp.MustCompile(`^\[\s*` + transformNamePattern + `\s*\]\s*_`)
+
+// transitionLineRe 识别过渡矩阵的写法 P_{b \to c}
+var transitionLineRe = regexp.MustCompile(`^P_\{\s*[a-zA-Z]+\s*\\(?:to|rightarrow)\b`)
+
+// changeBasisLineRe 识别基变换计算请求的开头，如 [\vec{v}]_b、[ \vec{v} ]_b
+var changeBasisLineRe = regexp.MustCompile(`\[\s*\\vec\{`)
+
+// evalSuffixPattern 匹配计算请求的结尾 \leftarrow \text{eval}
+const evalSuffixPattern = `\s*\\leftarrow\s*\\text\{eval\}\s*$`
+
+// vecComboLineRe 识别以线性组合定义向量的语句，如 \vec{w} = 2\vec{u} - \vec{v}
+var vecComboLineRe = regexp.MustCompile(`^\\vec\{[a-zA-Z]+\}(?:_[0-9]+)?\s*=.*\\vec\{`)
+
+// separatorRe 匹配语句末尾允许出现的分隔符，如 ,\quad
+var separatorRe = regexp.MustCompile(`^[\s,.;]*(?:\\q?quad)?[\s,.;]*$`)
+
+// transformNamePattern 匹配变换名：一个大写字母或 \mathcal{大写字母}，可带下标
+// 如 T、S、T_1、T_{12}、\mathcal{T}
+const transformNamePattern = `(?:\\mathcal\{[A-Z]\}|[A-Z])(?:_(?:\{[0-9a-zA-Z]+\}|[0-9a-zA-Z]))?`
+
+// transformCallRe 匹配变换作用在向量上的写法，如 T(\vec、\mathcal{T}(\vec
+var transformCallRe = regexp.MustCompile(`(?:^|[^\\a-zA-Z{])` + transformNamePattern + `\(\s*\\vec`)
+
+// canonicalTransformName 把源代码中的变换名规范化，使不同写法指向同一个变换
+// 单字符下标去掉花括号（T_{1} → T_1），多字符下标保留花括号（T_{12}），其余空白被删除
+func canonicalTransformName(raw string) string {
+	name := strings.Join(strings.Fields(raw), "")
+	if i := strings.Index(name, "_{"); i >= 0 && len(name)-i == 4 {
+		name = name[:i+1] + name[i+2:i+3]
+	}
+	return name
+}
+
 func classify(line string) StmtKind {
 	switch {
 	case strings.Contains(line, "pmatrix"):
 		return StmtVecAssign
-	case strings.Contains(line, "eval") && strings.Contains(line, "[\\vec"):
+	case strings.Contains(line, "eval") && matrixLineRe.MatchString(line):
+		return StmtEvalTransformMatrix
+	case strings.Contains(line, "eval") && transitionLineRe.MatchString(line):
+		return StmtEvalTransition
+	case strings.Contains(line, "eval") && changeBasisLineRe.MatchString(line):
 		return StmtEvalChangeBasis
-	case strings.Contains(line, "eval") && strings.Contains(line, "T(\\vec"):
+	case strings.Contains(line, "eval") && strings.Contains(line, "\\langle"):
+		return StmtEvalInner
+	case strings.Contains(line, "eval") && (strings.Contains(line, "\\|") || strings.Contains(line, "\\lVert")):
+		return StmtEvalNorm
+	case strings.Contains(line, "eval") && strings.Contains(line, "\\angle"):
+		return StmtEvalAngle
+	case strings.Contains(line, "eval") && strings.Contains(line, "\\circ"):
+		return StmtEvalCompose
+	case strings.Contains(line, "eval") && transformCallRe.MatchString(line):
 		return StmtEvalTransform
-	case strings.Contains(line, "T(\\vec"):
+	case vecComboLineRe.MatchString(line):
+		return StmtVecCombo
+	case transformCallRe.MatchString(line):
 		return StmtTransformAssign
 	case strings.Contains(line, "{"):
 		return StmtBasisAssign
@@ -100,11 +220,21 @@ func classify(line string) StmtKind {
 func NewLexer(r io.Reader) *Lexer {
 	return &Lexer{
 		scanner:           bufio.NewScanner(r),
-		vecAssignRe:       regexp.MustCompile(`\\vec\{([a-zA-Z]+)\}(?:_([0-9]+))?\s*=\s*\\begin\{pmatrix\}\s*([+-]?\d+)\s*\\\\\s*([+-]?\d+)\s*\\end\{pmatrix\}`),
+		vecAssignRe:       regexp.MustCompile(`\\vec\{([a-zA-Z]+)\}(?:_([0-9]+))?\s*=\s*\\begin\{pmatrix\}(.*?)\\end\{pmatrix\}`),
 		basisAssignRe:     regexp.MustCompile(`^([a-zA-Z]+)\s*=\s*\\\{\s*(.+)\s*\\\}$`),
 		evalChangeBasisRe: regexp.MustCompile(`^\[\s*\\vec\{([a-zA-Z]+)\}\s*\]\s*_\s*([a-zA-Z]+)\s*\\leftarrow\s*\\text\{eval\}\s*$`),
-		transformAssignRe: regexp.MustCompile(`([+-]?\s*\d*\.?\d*)\\vec\{([a-zA-Z][a-zA-Z0-9]*)\}(?:_([0-9]+))?`),
-		evalTransformRe:   regexp.MustCompile(`^([A-Z])\(\s*\\vec\{([a-zA-Z][a-zA-Z0-9]*)\}(?:_([0-9]+))?\s*\)\s*\\leftarrow\s*\\text\{eval\}\s*$`),
+		transformAssignRe: regexp.MustCompile(`([+-]?\s*(?:` + scalarPattern + `)?)\s*\\vec\{([a-zA-Z][a-zA-Z0-9]*)\}(?:_([0-9]+))?`),
+		vecComboRe:        regexp.MustCompile(`^\\vec\{([a-zA-Z]+)\}(?:_([0-9]+))?\s*=(.*)$`),
+		transformHeadRe:   regexp.MustCompile(`^(` + transformNamePattern + `)\(\s*\\vec\{([a-zA-Z]+)\}(?:_([0-9]+))?\s*\)\s*=(.*)$`),
+		evalTransformRe:   regexp.MustCompile(`^(` + transformNamePattern + `)\(\s*\\vec\{([a-zA-Z][a-zA-Z0-9]*)\}(?:_([0-9]+))?\s*\)\s*\\leftarrow\s*\\text\{eval\}\s*$`),
+		evalComposeRe:     regexp.MustCompile(`^\(\s*(` + transformNamePattern + `(?:\s*\\circ\s*` + transformNamePattern + `)+)\s*\)\s*\(\s*\\vec\{([a-zA-Z][a-zA-Z0-9]*)\}(?:_([0-9]+))?\s*\)\s*\\leftarrow\s*\\text\{eval\}\s*$`),
+		evalMatrixRe:      regexp.MustCompile(`^\[\s*(` + transformNamePattern + `)\s*\]\s*_\s*` + basisIndexPattern + `(?:\s*\^\s*` + basisIndexPattern + `)?` + evalSuffixPattern),
+		evalInnerRe:       regexp.MustCompile(`^\\langle\s*` + vecRefPattern + `\s*,\s*` + vecRefPattern + `\s*\\rangle` + evalSuffixPattern),
+		evalNormRe:        regexp.MustCompile(`^(?:\\\||\\lVert)\s*` + vecRefPattern + `\s*(?:\\\||\\rVert)` + evalSuffixPattern),
+		evalAngleRe:       regexp.MustCompile(`^\\angle\s*\(\s*` + vecRefPattern + `\s*,\s*` + vecRefPattern + `\s*\)` + evalSuffixPattern),
+		evalTransitionRe:  regexp.MustCompile(`^P_\{\s*([a-zA-Z]+)\s*\\(?:to|rightarrow)\s*([a-zA-Z]+)\s*\}(?:\s*\(\s*((?:` + vecRefPattern + `\s*,\s*)*` + vecRefPattern + `)\s*\))?` + evalSuffixPattern),
+		vecRefRe:          regexp.MustCompile(vecRefPattern),
+		circRe:            regexp.MustCompile(`\s*\\circ\s*`),
 		termRe:            regexp.MustCompile(`\\vec\{([a-zA-Z]+)\}(?:_([0-9]+))`),
 	}
 }
@@ -115,58 +245,138 @@ func NewLexer(r io.Reader) *Lexer {
 //	*Token: 读取的 token
 //	error: 读取过程中遇到的错误
 func (l *Lexer) Next() (*Token, error) {
-	for l.scanner.Scan() {
-		line := strings.TrimSpace(l.scanner.Text())
-
-		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "*") {
-			continue
+	if d := l.deferred; d != nil {
+		l.deferred = nil
+		return nil, d
+	}
+	for {
+		if len(l.pending) == 0 {
+			if err := l.fill(); err != nil {
+				return nil, err
+			}
+			if len(l.pending) == 0 {
+				return nil, nil
+			}
 		}
+		tok := l.pending[0]
+		l.pending = l.pending[1:]
+		line := strings.TrimSpace(tok.Text)
+		// off 是语句在 Text 中的字节偏移，正则匹配得到的下标都要加上它
+		off := len(tok.Text) - len(strings.TrimLeftFunc(tok.Text, unicode.IsSpace))
 
 		switch classify(line) {
 		case StmtVecAssign:
-			m := l.vecAssignRe.FindStringSubmatch(line)
+			m := l.vecAssignRe.FindStringSubmatchIndex(line)
 
 			if m == nil {
-				return nil, fmt.Errorf("invalid vector assignment: %s", line)
-			}
-			name := ""
-			if len(m[2]) == 0 {
-				name = m[1]
-			} else {
-				name = m[1] + m[2]
-			}
-			comp := make([]float64, len(m)-3)
-			for i, s := range m[3:] {
-				var err error
-				comp[i], err = strconv.ParseFloat(s, 64)
+				if strings.Contains(line, `\begin{pmatrix}`) && !strings.Contains(line, `\end{pmatrix}`) {
+					return nil, tok.errorf("", `invalid vector assignment: \begin{pmatrix} is not closed by \end{pmatrix}`)
+				}
+				return nil, tok.errorf("", `invalid vector assignment: expected \vec{v} = \begin{pmatrix} ... \end{pmatrix}`)
+			}
+			name := line[m[2]:m[3]]
+			if m[4] >= 0 {
+				name += line[m[4]:m[5]]
+			}
+			// pmatrix 的每一行是一个分量，行数即向量维数
+			rows := strings.Split(line[m[6]:m[7]], `\\`)
+			// 每行一个分量的写法常在最后一行也加上 \\，不算作一个分量
+			if n := len(rows); n > 1 && strings.TrimSpace(rows[n-1]) == "" {
+				rows = rows[:n-1]
+			}
+			comp := make([]float64, len(rows))
+			exact := make([]*big.Rat, len(rows))
+			raw := make([]string, len(rows))
+			start := off + m[6]
+			for i, s := range rows {
+				r, err := parseScalar(s)
 				if err != nil {
-					return nil, fmt.Errorf("invalid component value: %s", s)
+					lead := len(s) - len(strings.TrimLeftFunc(s, unicode.IsSpace))
+					text := strings.TrimSpace(s)
+					return nil, tok.errorAt(start+lead, start+lead+len(text), "invalid component value: %q", text)
 				}
+				comp[i], _ = r.Float64()
+				exact[i] = r
+				raw[i] = strings.TrimSpace(s)
+				start += len(s) + len(`\\`)
 			}
-			return &Token{Kind: "VectorAssign", Args: &VecAssignArgs{Name: name, Comp: comp}}, nil
+			tok.Kind = "VectorAssign"
+			tok.Args = &VecAssignArgs{Name: name, Comp: comp, Exact: exact, Raw: raw}
+			l.deferred = trailing(tok, line[m[1]:])
+			return tok, nil
 
 		case StmtEvalChangeBasis:
 			m := l.evalChangeBasisRe.FindStringSubmatch(line)
 			if m == nil {
-				return nil, fmt.Errorf("invalid basis change evaluation: %s", line)
+				return nil, tok.errorf("", "invalid basis change evaluation")
 			}
-			return &Token{Kind: "StmtEvalChangeBasis", Args: &EvalChangeBasisArgs{Vec: m[1], Basis: m[2]}}, nil
+			tok.Kind = "StmtEvalChangeBasis"
+			tok.Args = &EvalChangeBasisArgs{Vec: m[1], Basis: m[2]}
+			return tok, nil
 
-		case StmtTransformAssign:
-			terms := l.transformAssignRe.FindAllStringSubmatch(line, -1)
-			if len(terms) < 2 {
-				return nil, fmt.Errorf("invalid transform assignment: %s", line)
+		case StmtVecCombo:
+			m := l.vecComboRe.FindStringSubmatch(line)
+			if m == nil {
+				return nil, tok.errorf("", "invalid vector expression")
+			}
+			terms := l.transformAssignRe.FindAllStringSubmatch(m[3], -1)
+			// 线性组合之外只允许出现分隔符，其余文本（如未知的运算符）视为错误
+			if rest := l.transformAssignRe.ReplaceAllString(m[3], ""); !separatorRe.MatchString(rest) {
+				return nil, tok.errorf(strings.TrimSpace(rest), "invalid vector expression: unexpected %q", strings.TrimSpace(rest))
 			}
+			tok.Kind = "VectorCombo"
+			tok.Args = &VecComboArgs{Name: m[1] + m[2], RawTerms: terms}
+			return tok, nil
 
-			return &Token{
-				Kind: "StmtTransformAssign",
-				Args: &TransformAssignArgs{Transform: "T", DomainVec: terms[0][2:], RawTerms: terms[1:]},
-			}, nil
+		case StmtTransformAssign:
+			hm := l.transformHeadRe.FindStringSubmatchIndex(line)
+			if hm == nil {
+				// 写错的计算请求也会被当作变换规则
+				if strings.Contains(line, `\leftarrow`) || strings.Contains(line, `\text{eval}`) {
+					return nil, tok.errorf("", "invalid transform assignment: %s", guessReason(line))
+				}
+				return nil, tok.errorf("", "invalid transform assignment")
+			}
+			head := submatches(line, hm)
+			tm := l.transformAssignRe.FindAllStringSubmatchIndex(head[4], -1)
+			if len(tm) < 1 {
+				return nil, tok.errorf("", "invalid transform assignment")
+			}
+			args := &TransformAssignArgs{
+				Transform: canonicalTransformName(head[1]),
+				DomainVec: head[2:4],
+			}
+			for _, m := range tm {
+				args.RawTerms = append(args.RawTerms, submatches(head[4], m))
+				// 项中的向量从 \vec{ 开始，到匹配结束
+				at := off + hm[8] + m[4] - len(`\vec{`)
+				args.TermSpans = append(args.TermSpans, [2]int{at, off + hm[8] + m[1]})
+			}
+			tok.Kind = "StmtTransformAssign"
+			tok.Args = args
+			// 像是输出基中的向量却没有下标，多半是漏写了
+			for i, t := range args.RawTerms {
+				if t[3] == "" {
+					span := args.TermSpans[i]
+					l.deferred = tok.errorAt(span[0], span[1], `\vec{%s} has no subscript; the image of a basis vector is a combination of basis vectors such as \vec{%s}_1`, t[2], t[2])
+					l.deferred.Severity = SeverityWarning
+					return tok, nil
+				}
+			}
+			l.deferred = trailing(tok, l.transformAssignRe.ReplaceAllString(head[4], ""))
+			return tok, nil
 
 		case StmtBasisAssign:
 			m := l.basisAssignRe.FindStringSubmatch(line)
 			if m == nil {
-				return nil, fmt.Errorf("invalid basis assignment: %s", line)
+				// 含有花括号的行都被当作基的定义，写错的其它语句也会落到这里
+				switch {
+				case bareBasisRe.MatchString(line):
+					return nil, tok.errorf("", "invalid basis assignment: %s", guessReason(line))
+				case looksLikeMath(line):
+					return nil, tok.errorf("", "invalid statement: %s", guessReason(line))
+				}
+				return nil, tok.errorf("", "invalid basis assignment")
 			}
 			r := make([]string, 0, 3)
 			r = append(r, m[1])
@@ -175,27 +385,193 @@ func (l *Lexer) Next() (*Token, error) {
 			for i, _ := range items {
 				n := l.termRe.FindStringSubmatch(items[i])
 				if n == nil {
-					return nil, fmt.Errorf("invalid vector in basis: %s", items[i])
+					return nil, tok.errorf(strings.TrimSpace(items[i]), "invalid vector in basis: %s", strings.TrimSpace(items[i]))
 				}
 				r = append(r, n[1]+n[2])
 			}
-			return &Token{Kind: "BasisAssign", Args: &BasisAssignArgs{Name: r[0], Vecs: r[1:]}}, nil
+			tok.Kind = "BasisAssign"
+			tok.Args = &BasisAssignArgs{Name: r[0], Vecs: r[1:]}
+			return tok, nil
 
 		case StmtEvalTransform:
 			// 正则匹配 T(\vec{v}) \leftarrow eval
 			m := l.evalTransformRe.FindStringSubmatch(line)
 			if m == nil {
-				return nil, fmt.Errorf("invalid transform evaluation: %s", line)
+				return nil, tok.errorf("", "invalid transform evaluation")
 			}
 
 			args := &EvalTransformArgs{
-				Transform: m[1], //"T"
-				VecName:   m[2],
+				Transform: canonicalTransformName(m[1]),
+				VecName:   m[2] + m[3],
 			}
 
-			return &Token{Kind: "StmtEvalTransform", Args: args}, nil
+			tok.Kind = "StmtEvalTransform"
+			tok.Args = args
+			return tok, nil
+
+		case StmtEvalTransformMatrix:
+			// 正则匹配 [T]_{b}^{c} \leftarrow eval
+			m := l.evalMatrixRe.FindStringSubmatch(line)
+			if m == nil {
+				return nil, tok.errorf("", "invalid transform matrix evaluation")
+			}
+			args := &EvalTransformMatrixArgs{Transform: canonicalTransformName(m[1]), From: m[2] + m[3], To: m[4] + m[5]}
+			if args.To == "" {
+				args.To = args.From
+			}
+			tok.Kind = "StmtEvalTransformMatrix"
+			tok.Args = args
+			return tok, nil
+
+		case StmtEvalTransition:
+			// 正则匹配 P_{b \to c} 或 P_{b \to c}(\vec{u}, \vec{v}) \leftarrow eval
+			m := l.evalTransitionRe.FindStringSubmatch(line)
+			if m == nil {
+				return nil, tok.errorf("", "invalid transition matrix evaluation")
+			}
+			args := &EvalTransitionArgs{From: m[1], To: m[2]}
+			for _, v := range l.vecRefRe.FindAllStringSubmatch(m[3], -1) {
+				args.Vecs = append(args.Vecs, v[1]+v[2])
+			}
+			tok.Kind = "StmtEvalTransition"
+			tok.Args = args
+			return tok, nil
+
+		case StmtEvalInner:
+			m := l.evalInnerRe.FindStringSubmatch(line)
+			if m == nil {
+				return nil, tok.errorf("", "invalid inner product evaluation")
+			}
+			tok.Kind = "StmtEvalInner"
+			tok.Args = &EvalInnerArgs{U: m[1] + m[2], V: m[3] + m[4]}
+			return tok, nil
+
+		case StmtEvalNorm:
+			m := l.evalNormRe.FindStringSubmatch(line)
+			if m == nil {
+				return nil, tok.errorf("", "invalid norm evaluation")
+			}
+			tok.Kind = "StmtEvalNorm"
+			tok.Args = &EvalNormArgs{VecName: m[1] + m[2]}
+			return tok, nil
+
+		case StmtEvalAngle:
+			m := l.evalAngleRe.FindStringSubmatch(line)
+			if m == nil {
+				return nil, tok.errorf("", "invalid angle evaluation")
+			}
+			tok.Kind = "StmtEvalAngle"
+			tok.Args = &EvalAngleArgs{U: m[1] + m[2], V: m[3] + m[4]}
+			return tok, nil
+
+		case StmtEvalCompose:
+			// 正则匹配 (S \circ T)(\vec{v}) \leftarrow eval
+			m := l.evalComposeRe.FindStringSubmatch(line)
+			if m == nil {
+				return nil, tok.errorf("", "invalid composition evaluation")
+			}
+			names := l.circRe.Split(m[1], -1)
+			for i := range names {
+				names[i] = canonicalTransformName(names[i])
+			}
+			tok.Kind = "StmtEvalCompose"
+			tok.Args = &EvalComposeArgs{Transforms: names, VecName: m[2] + m[3]}
+			return tok, nil
+
+		default:
+			// 含有 mathlang 记号却不是任何语句，多半是写错了，给出警告而不是悄悄忽略
+			if d := nearMiss(tok, line); d != nil {
+				return nil, d
+			}
+		}
+
+	}
+}
+
+// fill 读取下一行或下一个显示公式块，把其中的语句放入 pending
+// 显示公式块之外的每一行是一条语句；块内的内容先拼接再切分，见 splitBlock
+// 返回：
+//
+//	error: 显示公式块没有结束定界符时返回错误
+func (l *Lexer) fill() error {
+	for l.scanner.Scan() {
+		l.line++
+		raw := l.scanner.Text()
+		line := strings.TrimSpace(raw)
+
+		if l.strict {
+			if l.prose(raw, line) {
+				continue
+			}
+		} else if l.org.line(line, l.line) {
+			// org 标题和属性抽屉只更新当前所在的标题
+			continue
+		}
+		// 以 : 开头的是 org 的属性、抽屉和定宽行，包括 -write-back 写入的结果块，不是语句
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "*") || strings.HasPrefix(line, ":") {
+			continue
+		}
+		off := len(raw) - len(strings.TrimLeftFunc(raw, unicode.IsSpace))
+		open, close, ok := displayOpen(line)
+		if !ok {
+			l.pending = append(l.pending, &Token{Pos: Pos{File: l.file, Line: l.line, Col: off + 1}, Text: raw, Heading: l.org.current})
+			return nil
+		}
+		return l.block(raw, off+len(open), open, close)
+	}
+	if src := l.fence.src; src != nil {
+		l.fence.src = nil
+		return src.errorf("", "unterminated source block: missing #+end_src")
+	}
+	return nil
+}
+
+// block 读取一个显示公式块直到结束定界符，把切分出的语句放入 pending
+// 参数：
+//
+//	raw: 开始定界符所在的物理行
+//	col: 开始定界符之后的内容在 raw 中的字节偏移
+//	open, close: 开始和结束定界符
+//
+// 返回：
+//
+//	error: 块没有结束定界符时返回错误
+func (l *Lexer) block(raw string, col int, open, close string) error {
+	start := col - len(open) // 开始定界符在第一行中的偏移
+	first := &Token{Pos: Pos{File: l.file, Line: l.line, Col: start + 1}, Text: raw, Heading: l.org.current}
+	var lines []blockLine
+	for {
+		text := raw[col:]
+		if i := strings.Index(text, close); i >= 0 {
+			lines = append(lines, blockLine{text: text[:i], line: l.line, col: col, raw: raw})
+			break
+		}
+		lines = append(lines, blockLine{text: text, line: l.line, col: col, raw: raw})
+		if !l.scanner.Scan() {
+			return first.errorAt(start, start+len(open), "unterminated display math: missing %s", close)
 		}
+		l.line++
+		raw, col = l.scanner.Text(), 0
+	}
+	for _, st := range splitBlock(lines) {
+		f := st.frags[0]
+		l.pending = append(l.pending, &Token{
+			Pos:     Pos{File: l.file, Line: f.line, Col: f.col + 1},
+			Text:    st.text,
+			Heading: first.Heading,
+			frags:   st.frags,
+		})
+	}
+	return nil
+}
 
+// submatches 把 FindStringSubmatchIndex 的结果转为 FindStringSubmatch 的形式，没有参与匹配的分组为空串
+func submatches(s string, m []int) []string {
+	out := make([]string, len(m)/2)
+	for i := range out {
+		if m[2*i] >= 0 {
+			out[i] = s[m[2*i]:m[2*i+1]]
+		}
 	}
-	return nil, nil
+	return out
 }
@@ -0,0 +1,95 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+)
+
+// mathTokens 是 mathlang 语句中特有的记号，含有它们却没有被识别为语句的行很可能是写错了
+var mathTokens = []string{`\vec`, `\leftarrow`, `pmatrix`, `\text{eval}`}
+
+var (
+	evalSuffixRe   = regexp.MustCompile(evalSuffixPattern)
+	otherMatrixRe  = regexp.MustCompile(`\\begin\{([bBvV]?matrix|array)\}`)
+	bareBasisRe    = regexp.MustCompile(`^[a-zA-Z]+\s*=\s*\{`)
+	vecHeadRe      = regexp.MustCompile(`^\\vec\{[a-zA-Z]+\}(?:_[0-9]+)?\s*=`)
+	transformLHSRe = regexp.MustCompile(`^` + transformNamePattern + `\(`)
+)
+
+// looksLikeMath 报告语句中是否含有 mathlang 特有的记号
+func looksLikeMath(line string) bool {
+	for _, t := range mathTokens {
+		if strings.Contains(line, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// nearMiss 对没有被识别为任何语句、但含有 mathlang 记号的语句给出警告，并猜测原因
+// 参数：
+//
+//	tok: 未被识别的语句
+//	line: 去除首尾空白的语句文本
+//
+// 返回：
+//
+//	*Diagnostic: 警告，语句不像 mathlang 语句时为 nil
+func nearMiss(tok *Token, line string) *Diagnostic {
+	if !looksLikeMath(line) {
+		return nil
+	}
+	d := tok.errorf("", "statement ignored: %s", guessReason(line))
+	d.Severity = SeverityWarning
+	return d
+}
+
+// guessReason 猜测一条含有 mathlang 记号的语句没有被识别的原因
+func guessReason(line string) string {
+	hasArrow := strings.Contains(line, `\leftarrow`)
+	hasEval := strings.Contains(line, `\text{eval}`)
+	switch {
+	case hasArrow && !evalSuffixRe.MatchString(line):
+		return `an eval request must end with \leftarrow \text{eval}`
+	case hasEval && !hasArrow:
+		return `\text{eval} needs \leftarrow before it, as in T(\vec{v}) \leftarrow \text{eval}`
+	case hasEval && !strings.Contains(line, `\vec`):
+		return `the eval request does not name a vector; vectors are written \vec{v}`
+	case hasEval:
+		return `unrecognised eval request; supported forms are [\vec{v}]_b, T(\vec{v}), (S \circ T)(\vec{v}), [T]_{b}^{c}, P_{b \to c}, \langle \vec{u}, \vec{v} \rangle, \|\vec{v}\| and \angle(\vec{u}, \vec{v})`
+	case otherMatrixRe.MatchString(line):
+		return "vectors must be written with pmatrix, not " + otherMatrixRe.FindStringSubmatch(line)[1]
+	case bareBasisRe.MatchString(line):
+		return `the braces of a basis must be escaped, as in b = \{\vec{b}_1, \vec{b}_2\}`
+	case vecHeadRe.MatchString(line):
+		return `a vector is defined by \begin{pmatrix} ... \end{pmatrix} or by a combination of other vectors`
+	case transformLHSRe.MatchString(line):
+		return `a transform rule applies the transform to a vector, as in T(\vec{b}_1) = 2\vec{c}_1`
+	default:
+		return "not a recognised mathlang statement"
+	}
+}
+
+// trailing 对语句末尾被忽略的文本给出警告，如一行中以 \quad 分隔的第二条语句
+// 参数：
+//
+//	tok: 已识别的语句
+//	rest: 语句中没有被读取的文本
+//
+// 返回：
+//
+//	*Diagnostic: 警告，rest 只含分隔符时为 nil
+func trailing(tok *Token, rest string) *Diagnostic {
+	if separatorRe.MatchString(rest) {
+		return nil
+	}
+	rest = strings.Trim(rest, " \t,.;")
+	rest = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(rest, `\qquad`), `\quad`))
+	msg := "text after the statement is ignored"
+	if looksLikeMath(rest) {
+		msg = `only the first statement on a line is read; put the rest on its own line or inside \[ ... \]`
+	}
+	d := tok.errorf(rest, "%s", msg)
+	d.Severity = SeverityWarning
+	return d
+}
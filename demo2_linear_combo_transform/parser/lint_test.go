@@ -0,0 +1,72 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGuessReason(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`[\vec{v}]_b \leftarrow \text{evaluate}`, `an eval request must end with \leftarrow \text{eval}`},
+		{`T(v) \leftarrow eval`, `an eval request must end with \leftarrow \text{eval}`},
+		{`[\vec{v}]_b = \text{eval}`, `\text{eval} needs \leftarrow before it, as in T(\vec{v}) \leftarrow \text{eval}`},
+		{`[v]_b \leftarrow \text{eval}`, `the eval request does not name a vector; vectors are written \vec{v}`},
+		{`\det(\vec{v}) \leftarrow \text{eval}`, `unrecognised eval request; supported forms are [\vec{v}]_b, T(\vec{v}), (S \circ T)(\vec{v}), [T]_{b}^{c}, P_{b \to c}, \langle \vec{u}, \vec{v} \rangle, \|\vec{v}\| and \angle(\vec{u}, \vec{v})`},
+		{`\vec{v} = \begin{bmatrix}1\\2\end{bmatrix}`, "vectors must be written with pmatrix, not bmatrix"},
+		{`\vec{v} = \begin{array}{c}1\\2\end{array}`, "vectors must be written with pmatrix, not array"},
+		{`b = {\vec{b}_1, \vec{b}_2}`, `the braces of a basis must be escaped, as in b = \{\vec{b}_1, \vec{b}_2\}`},
+		{`\vec{v} = (1, 2)`, `a vector is defined by \begin{pmatrix} ... \end{pmatrix} or by a combination of other vectors`},
+		{`T(b_1) = 2\vec{c}_1`, `a transform rule applies the transform to a vector, as in T(\vec{b}_1) = 2\vec{c}_1`},
+		{`see \vec{v} above`, "not a recognised mathlang statement"},
+	}
+	for _, tt := range tests {
+		if got := guessReason(tt.line); got != tt.want {
+			t.Errorf("guessReason(%q):\n got %s\nwant %s", tt.line, got, tt.want)
+		}
+	}
+}
+
+// 没有被识别的语句：不含花括号的行给出警告，含有花括号的行被当作基的定义，报告带有原因的错误
+func TestNearMiss(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    string // 唯一一条诊断信息，为空时不应有诊断信息
+		warning bool
+	}{
+		{`Some prose about vectors and bases.`, "", false},
+		{`\vec{v} = \begin{pmatrix}1\\2\end{pmatrix}`, "", false},
+		{`\vec v = 1`, "1:1: statement ignored: not a recognised mathlang statement", true},
+		{`  T(v) \leftarrow eval`, `1:3: statement ignored: an eval request must end with \leftarrow \text{eval}`, true},
+		{`x \leftarrow \text{eval}`, `1:1: invalid statement: the eval request does not name a vector; vectors are written \vec{v}`, false},
+		{`b = {\vec{b}_1, \vec{b}_2}`, `1:1: invalid basis assignment: the braces of a basis must be escaped, as in b = \{\vec{b}_1, \vec{b}_2\}`, false},
+		{`\vec{v} = \begin{bmatrix}1\\2\end{bmatrix}`, "1:1: invalid statement: vectors must be written with pmatrix, not bmatrix", false},
+		// 一行中第一条语句之后的文本
+		{`\vec{v} = \begin{pmatrix}1\\2\end{pmatrix} \quad`, "", false},
+		{`\vec{v} = \begin{pmatrix}1\\2\end{pmatrix}, for example.`, "1:45: text after the statement is ignored", true},
+		{`\vec{v} = \begin{pmatrix}1\\2\end{pmatrix} \quad \|\vec{v}\| \leftarrow \text{eval}`,
+			`1:50: only the first statement on a line is read; put the rest on its own line or inside \[ ... \]`, true},
+	}
+	for _, tt := range tests {
+		_, diags := ParseAll("", strings.NewReader(tt.line+"\n"))
+		if tt.want == "" {
+			if len(diags) != 0 {
+				t.Errorf("%s: unexpected diagnostics %v", tt.line, diags)
+			}
+			continue
+		}
+		if len(diags) != 1 {
+			t.Errorf("%s: got %d diagnostics, want 1: %v", tt.line, len(diags), diags)
+			continue
+		}
+		d := diags[0]
+		if got := strings.TrimPrefix(d.Error(), "<stdin>:"); got != tt.want {
+			t.Errorf("%s:\n got %s\nwant %s", tt.line, got, tt.want)
+		}
+		if (d.Severity == SeverityWarning) != tt.warning {
+			t.Errorf("%s: severity %v, want warning %v", tt.line, d.Severity, tt.warning)
+		}
+	}
+}
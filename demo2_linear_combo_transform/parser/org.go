@@ -0,0 +1,167 @@
+package parser
+
+import (
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Heading 是 org 笔记中的一个标题，语句属于它之后最近的标题
+type Heading struct {
+	Level  int               // 星号个数
+	Title  string            // 标题文字，不含星号和标签
+	Tags   []string          // 标题行末尾的标签，如 :draft:linalg:
+	Props  map[string]string // 紧跟标题的 :PROPERTIES: 抽屉中的属性，如 Status、Source
+	Parent *Heading          // 上一级标题，顶层标题为 nil
+	Line   int               // 标题所在的行号
+}
+
+// headingRe 匹配 org 标题行：星号、空格、标题文字和可选的标签
+var headingRe = regexp.MustCompile(`^(\*+)\s+(.*?)(?:\s+:([\w@#%:]+):)?\s*$`)
+
+// propertyRe 匹配属性抽屉中的一行，如 :Status: draft
+var propertyRe = regexp.MustCompile(`^:([^:\s]+):\s*(.*?)\s*$`)
+
+// Path 返回从顶层到该标题的标题路径，如 "LinCombTransform / My understanding / Evaluate"
+func (h *Heading) Path() string {
+	if h == nil {
+		return ""
+	}
+	if h.Parent == nil {
+		return h.Title
+	}
+	return h.Parent.Path() + " / " + h.Title
+}
+
+// Property 查找属性，自身没有时沿上级标题继承
+func (h *Heading) Property(key string) (string, bool) {
+	for ; h != nil; h = h.Parent {
+		for k, v := range h.Props {
+			if strings.EqualFold(k, key) {
+				return v, true
+			}
+		}
+	}
+	return "", false
+}
+
+// HasTag 报告标题或它的上级标题是否带有标签 tag
+func (h *Heading) HasTag(tag string) bool {
+	for ; h != nil; h = h.Parent {
+		if slices.Contains(h.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// within 报告标题或它的上级标题中是否有标题文字为 title 的
+func (h *Heading) within(title string) bool {
+	for ; h != nil; h = h.Parent {
+		if strings.EqualFold(h.Title, title) {
+			return true
+		}
+	}
+	return false
+}
+
+// orgState 在逐行扫描时跟踪当前所在的标题和属性抽屉
+type orgState struct {
+	headings []*Heading // 已读取的全部标题，按行号排列
+	current  *Heading   // 当前所在的标题，第一个标题之前为 nil
+	inProps  bool       // 是否在当前标题的属性抽屉中
+}
+
+// line 处理一行结构性的 org 内容（标题或属性抽屉）
+// 参数：
+//
+//	line: 去掉首尾空白的源代码行
+//	n: 行号
+//
+// 返回：
+//
+//	bool: 该行是标题或属性抽屉的一部分，不是语句
+func (o *orgState) line(line string, n int) bool {
+	if o.inProps {
+		if strings.EqualFold(line, ":END:") {
+			o.inProps = false
+		} else if m := propertyRe.FindStringSubmatch(line); m != nil {
+			o.current.Props[m[1]] = m[2]
+		}
+		return true
+	}
+	if o.current != nil && strings.EqualFold(line, ":PROPERTIES:") {
+		o.inProps = true
+		return true
+	}
+	m := headingRe.FindStringSubmatch(line)
+	if m == nil {
+		return false
+	}
+	h := &Heading{Level: len(m[1]), Title: m[2], Props: make(map[string]string), Line: n}
+	if m[3] != "" {
+		h.Tags = strings.Split(m[3], ":")
+	}
+	for p := o.current; p != nil; p = p.Parent {
+		if p.Level < h.Level {
+			h.Parent = p
+			break
+		}
+	}
+	o.headings = append(o.headings, h)
+	o.current = h
+	return true
+}
+
+// HeadingAt 返回第 line 行所属的标题，即它之前最近的标题，没有时返回 nil
+func (a *AST) HeadingAt(line int) *Heading {
+	i := sort.Search(len(a.Headings), func(i int) bool { return a.Headings[i].Line > line })
+	if i == 0 {
+		return nil
+	}
+	return a.Headings[i-1]
+}
+
+// Selection 决定 org 笔记中哪些子树和内容参与解析和计算
+type Selection struct {
+	SkipStatus []string // 跳过 Status 属性（可继承）为其中之一的子树，如 draft
+	SkipTags   []string // 跳过带有其中任一标签（可继承）的子树
+	Sections   []string // 非空时只执行标题路径中含有其中之一的子树中的计算请求，定义仍然全部读取
+	// Strict 为 true 时只解释 #+begin_src mathlang ... #+end_src 源代码块和紧接在 #+mathlang 指令之后的显示公式块，
+	// 其余内容都是正文，即使看起来像语句也不解析、不警告；默认按行识别笔记中的全部语句
+	Strict bool
+}
+
+// skips 报告语句所在的子树是否整个被跳过
+func (s *Selection) skips(h *Heading) bool {
+	if s == nil {
+		return false
+	}
+	if status, ok := h.Property("Status"); ok {
+		for _, skip := range s.SkipStatus {
+			if strings.EqualFold(status, skip) {
+				return true
+			}
+		}
+	}
+	for _, tag := range s.SkipTags {
+		if h.HasTag(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluates 报告子树中的计算请求是否执行
+func (s *Selection) evaluates(h *Heading) bool {
+	if s == nil || len(s.Sections) == 0 {
+		return true
+	}
+	for _, title := range s.Sections {
+		if h.within(title) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// orgNote 的第 10 行有一个错误；Draft 子树的 Status 为 draft，Checks 带有 exam 标签，Deep 带有 skip 标签
+const orgNote = `* Setup
+\vec{b}_1 = \begin{pmatrix}1\\0\end{pmatrix}
+\vec{b}_2 = \begin{pmatrix}0\\1\end{pmatrix}
+b = \{\vec{b}_1, \vec{b}_2\}
+\vec{v} = \begin{pmatrix}1\\2\end{pmatrix}
+* Draft
+:PROPERTIES:
+:Status: draft
+:END:
+\vec{x} = \begin{pmatrix}1\\q\end{pmatrix}
+[\vec{v}]_b \leftarrow \text{eval}
+** Nested
+\|\vec{v}\| \leftarrow \text{eval}
+* Checks                                                      :exam:
+[\vec{v}]_b \leftarrow \text{eval}
+** Evaluate
+\|\vec{v}\| \leftarrow \text{eval}
+*** Deep                                                       :skip:linalg:
+\vec{y} = \begin{pmatrix}3\\4\end{pmatrix}
+`
+
+func TestSelection(t *testing.T) {
+	tests := []struct {
+		name  string
+		sel   *Selection
+		vecs  string // 定义的向量，按名称排序
+		evals string // 计算请求所在的行
+		diags int
+	}{
+		{"all", nil, "b1 b2 v y", "11 13 15 17", 1},
+		{"empty selection", &Selection{}, "b1 b2 v y", "11 13 15 17", 1},
+		{"skip status", &Selection{SkipStatus: []string{"draft"}}, "b1 b2 v y", "15 17", 0},
+		{"skip status ignores case", &Selection{SkipStatus: []string{"final", "DRAFT"}}, "b1 b2 v y", "15 17", 0},
+		{"skip tag", &Selection{SkipTags: []string{"exam"}}, "b1 b2 v", "11 13", 1},
+		{"skip inner tag", &Selection{SkipTags: []string{"linalg"}}, "b1 b2 v", "11 13 15 17", 1},
+		// 只执行选中的子树中的计算请求，定义和定义中的错误仍然全部读取
+		{"section", &Selection{Sections: []string{"Evaluate"}}, "b1 b2 v y", "17", 1},
+		{"section with subsections", &Selection{Sections: []string{"checks", "Nested"}}, "b1 b2 v y", "13 15 17", 1},
+		{"section and skip", &Selection{Sections: []string{"Draft"}, SkipStatus: []string{"draft"}}, "b1 b2 v y", "", 0},
+	}
+	for _, tt := range tests {
+		ast, diags := ParseSelected("", strings.NewReader(orgNote), tt.sel)
+		var names []string
+		for name := range ast.Vecs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var lines []string
+		for _, e := range ast.Evals {
+			lines = append(lines, fmt.Sprint(e.SourceLine()))
+		}
+		if got := strings.Join(names, " "); got != tt.vecs {
+			t.Errorf("%s: vectors %q, want %q", tt.name, got, tt.vecs)
+		}
+		if got := strings.Join(lines, " "); got != tt.evals {
+			t.Errorf("%s: evals on lines %q, want %q", tt.name, got, tt.evals)
+		}
+		if len(diags) != tt.diags {
+			t.Errorf("%s: %d diagnostics, want %d: %v", tt.name, len(diags), tt.diags, diags)
+		}
+	}
+}
+
+func TestHeadings(t *testing.T) {
+	ast, _ := ParseAll("", strings.NewReader(orgNote))
+	tests := []struct {
+		line   int
+		path   string
+		status string
+		tags   string // 带有的标签，包括继承的
+	}{
+		{1, "Setup", "", ""},
+		{5, "Setup", "", ""},
+		{10, "Draft", "draft", ""},
+		{13, "Draft / Nested", "draft", ""},
+		{15, "Checks", "", "exam"},
+		{17, "Checks / Evaluate", "", "exam"},
+		{19, "Checks / Evaluate / Deep", "", "exam skip linalg"},
+	}
+	for _, tt := range tests {
+		h := ast.HeadingAt(tt.line)
+		if got := h.Path(); got != tt.path {
+			t.Errorf("line %d: path %q, want %q", tt.line, got, tt.path)
+		}
+		if got, _ := h.Property("status"); got != tt.status {
+			t.Errorf("line %d: status %q, want %q", tt.line, got, tt.status)
+		}
+		var tags []string
+		for _, tag := range []string{"exam", "skip", "linalg", "draft"} {
+			if h.HasTag(tag) {
+				tags = append(tags, tag)
+			}
+		}
+		if got := strings.Join(tags, " "); got != tt.tags {
+			t.Errorf("line %d: tags %q, want %q", tt.line, got, tt.tags)
+		}
+	}
+	if h := ast.HeadingAt(19); h.Title != "Deep" || h.Level != 3 || h.Line != 18 {
+		t.Errorf("heading at line 19: %+v", h)
+	}
+	if ast.HeadingAt(0) != nil {
+		t.Error("line 0 has a heading")
+	}
+	if len(ast.Headings) != 6 {
+		t.Errorf("%d headings, want 6", len(ast.Headings))
+	}
+}
@@ -1,12 +1,10 @@
 package parser
 
 import (
-	"fmt"
 	"io"
 	"regexp"
-	"strconv"
 	"strings"
-	// "strconv"
+	"unicode"
 )
 
 // ParseReader 从输入流中解析线性代数表达式，构建抽象语法树
@@ -19,147 +17,507 @@ import (
 //	*AST: 构建的抽象语法树
 //	error: 解析过程中遇到的错误
 func ParseReader(r io.Reader) (*AST, error) {
+	return ParseFile("", r)
+}
+
+// ParseFile 与 ParseReader 相同，但会把文件名记录到 token 和诊断信息的位置中
+// 参数：
+//
+//	filename: 文件名，用于诊断信息
+//	r: 输入流
+//
+// 返回：
+//
+//	*AST: 构建的抽象语法树
+//	error: 遇到的第一个错误，类型为 *Diagnostic
+func ParseFile(filename string, r io.Reader) (*AST, error) {
 	l := NewLexer(r)
-	ast := &AST{
-		Bases:      make(map[string]*Basis),
-		Vecs:       make(map[string]*Vec),
-		Transforms: make(map[string]*TransformRule),
-	}
-	var curBasis string
+	l.file = filename
+	b := newBuilder()
 	for {
 		tok, err := l.Next()
+		if d, ok := err.(*Diagnostic); ok && d.Severity == SeverityWarning {
+			continue
+		}
 		if err != nil {
 			return nil, err
 		}
 		if tok == nil {
 			break
 		}
-		switch tok.Kind {
-		case "BasisAssign":
-			// fmt.Println("tok.Args", tok.Args)
-			args := tok.Args.(*BasisAssignArgs)
-			curBasis = args.Name
-
-			// 检查基名称是否符合规范（单个小写字母）
-			if !regexp.MustCompile(`^[a-z]$`).MatchString(curBasis) {
-				return nil, fmt.Errorf("invalid basis name: %s, basis name should be a single lowercase letter", curBasis)
-			}
-
-			if _, ok := ast.Bases[curBasis]; ok {
-				return nil, fmt.Errorf("basis redefined: %s", curBasis)
-			}
-			ast.Bases[curBasis] = &Basis{Name: curBasis}
-
-			// 检查分量名称是否符合规范（基名称加上数字下标）
-			for _, vn := range args.Vecs {
-				key := vn // e.g. "b1"
-
-				// 检查分量名称是否以基名称开头，后跟数字
-				if !regexp.MustCompile(`^` + curBasis + `\d+$`).MatchString(key) {
-					return nil, fmt.Errorf("invalid vector name in basis %s: %s, vector name should be %s followed by number", curBasis, key, curBasis)
-				}
-
-				if vec, ok := ast.Vecs[key]; !ok {
-					return nil, fmt.Errorf("basis uses undefined vector: %s", key)
-				} else {
-					ast.Bases[curBasis].Vecs = append(ast.Bases[curBasis].Vecs, vec)
-					// 设置向量的 Basis 字段为当前基
-					vec.Basis = ast.Bases[curBasis]
-				}
-			}
-
-		case "VectorAssign":
-			args := tok.Args.(*VecAssignArgs)
-			v := &Vec{Name: args.Name, Comp: args.Comp}
-			ast.Vecs[args.Name] = v
-
-		case "StmtTransformAssign":
-			args := tok.Args.(*TransformAssignArgs)
-			linearTerms := make([]LinearTerm, 0)
-			toBasis := args.RawTerms[0][2]
-			toBasisChecker := true
-			domainVec := args.DomainVec[0] + args.DomainVec[1]
-
-			for _, t := range args.RawTerms {
-				if toBasisChecker {
-					toBasisChecker = (toBasis == t[2])
-				} else {
-					return nil, fmt.Errorf("to Basis error: inconsistent basis in linear combination")
-				}
-
-				coeffStr := strings.ReplaceAll(t[1], " ", "")
-				coeff := 1.0
-				if coeffStr == "" || coeffStr == "+" {
-					coeff = 1
-				} else if coeffStr == "-" {
-					coeff = -1
-				} else {
-					var err error
-					coeff, err = strconv.ParseFloat(coeffStr, 64)
-					if err != nil {
-						return nil, fmt.Errorf("invalid coefficient: %s", coeffStr)
-					}
-				}
-				vec := t[2] + t[3]
-				linearTerms = append(linearTerms, LinearTerm{Coeff: coeff, Vec: vec})
-			}
-			// 1. 查或建 TransformRule
-			tr, ok := ast.Transforms[args.Transform]
+		if err := b.stmt(tok); err != nil {
+			return nil, err
+		}
+	}
+	b.ast.Headings = l.org.headings
+	return b.ast, nil
+}
+
+// ParseAll 以容错模式解析输入：出错的语句被跳过，其余语句继续构建抽象语法树
+// 由前面失败的定义引起的“未定义符号”错误会被抑制，依赖这些符号的语句也一并跳过
+// 参数：
+//
+//	filename: 文件名，用于诊断信息
+//	r: 输入流
+//
+// 返回：
+//
+//	*AST: 由所有成功解析的语句构建的抽象语法树
+//	Diagnostics: 按出现顺序排列的全部错误和警告
+func ParseAll(filename string, r io.Reader) (*AST, Diagnostics) {
+	return ParseSelected(filename, r, nil)
+}
+
+// ParseSelected 与 ParseAll 相同，但只解析 sel 选中的 org 子树，sel.Strict 为 true 时只解析 mathlang 块
+// 被跳过的子树中的语句和错误都被忽略；sel 为 nil 时解析全部内容
+// 参数：
+//
+//	filename: 文件名，用于诊断信息
+//	r: 输入流
+//	sel: 子树的选择条件
+//
+// 返回：
+//
+//	*AST: 由选中的语句构建的抽象语法树
+//	Diagnostics: 选中的语句的全部错误和警告
+func ParseSelected(filename string, r io.Reader, sel *Selection) (*AST, Diagnostics) {
+	l := NewLexer(r)
+	l.file = filename
+	l.strict = sel != nil && sel.Strict
+	b := newBuilder()
+	b.consume(l, sel)
+	b.ast.Headings = l.org.headings
+	return b.ast, b.diags
+}
+
+// consume 以容错模式读取词法分析器中的全部语句并加入抽象语法树
+// 参数：
+//
+//	l: 词法分析器
+//	sel: 子树的选择条件，为 nil 时读取全部语句
+func (b *builder) consume(l *Lexer, sel *Selection) {
+	for {
+		tok, err := l.Next()
+		if err == nil && tok == nil {
+			break
+		}
+		if err == nil {
+			if sel.skips(tok.Heading) || strings.HasPrefix(tok.Kind, "StmtEval") && !sel.evaluates(tok.Heading) {
+				continue
+			}
+			err = b.stmt(tok)
+		}
+		if d, ok := err.(*Diagnostic); ok {
+			if sel.skips(d.Heading) {
+				continue
+			}
+			// 词法分析的警告不影响语句本身，也不使任何符号失败
+			if d.Severity == SeverityWarning {
+				b.warn(d)
+				continue
+			}
+			b.fail(d)
+		}
+	}
+}
+
+// builder 逐条消费 token，增量地构建抽象语法树
+type builder struct {
+	ast    *AST
+	diags  Diagnostics     // 已收集的诊断信息
+	failed map[string]bool // 定义失败的符号，键为 "vec:b1"、"basis:b"、"transform:T"
+	rules  map[string]Pos  // 已定义的变换规则，键为 "T/b1"，用于重复定义的警告
+}
+
+// newBuilder 创建一个空的 builder
+func newBuilder() *builder {
+	return &builder{
+		ast: &AST{
+			Bases:      make(map[string]*Basis),
+			Vecs:       make(map[string]*Vec),
+			Transforms: make(map[string]*TransformRule),
+		},
+		failed: make(map[string]bool),
+		rules:  make(map[string]Pos),
+	}
+}
+
+var (
+	failedVecRe       = regexp.MustCompile(`\\vec\{([a-zA-Z]+)\}(?:_([0-9]+))?\s*=`)
+	failedBasisRe     = regexp.MustCompile(`^\s*([a-zA-Z]+)\s*=\s*\\\{`)
+	failedTransformRe = regexp.MustCompile(`^\s*(` + transformNamePattern + `)\(\s*\\vec\{[^}]*\}(?:_[0-9]+)?\s*\)\s*=`)
+)
+
+// fail 记录一条失败语句的诊断信息，并把该语句试图定义的符号标记为失败
+// 级联错误（引用了失败符号的“未定义”错误）不会被记录
+// 参数：
+//
+//	d: 语句的诊断信息
+func (b *builder) fail(d *Diagnostic) {
+	if !d.cascade {
+		b.diags = append(b.diags, d)
+	}
+	// 从语句中尽量识别出被定义的符号，后续引用它的语句不再重复报错
+	stmt := d.stmt
+	if stmt == "" {
+		stmt = d.Source
+	}
+	if m := failedVecRe.FindStringSubmatch(stmt); m != nil {
+		b.failed["vec:"+m[1]+m[2]] = true
+	} else if m := failedBasisRe.FindStringSubmatch(stmt); m != nil {
+		b.failed["basis:"+m[1]] = true
+	} else if m := failedTransformRe.FindStringSubmatch(stmt); m != nil {
+		b.failed["transform:"+canonicalTransformName(m[1])] = true
+	}
+}
+
+// undefined 构造一个“未定义符号”的诊断信息
+// 如果该符号的定义曾经失败，诊断信息被标记为级联错误，在容错模式下会被抑制
+// 参数：
+//
+//	tok: 出错的 token
+//	key: 符号键，如 "vec:b3"
+//	sub: 出错片段的原文
+//	format, args: 诊断消息
+//
+// 返回：
+//
+//	*Diagnostic: 诊断信息
+func (b *builder) undefined(tok *Token, key, sub, format string, args ...any) *Diagnostic {
+	d := tok.errorf(sub, format, args...)
+	d.cascade = b.failed[key]
+	return d
+}
+
+// warn 记录一条警告
+func (b *builder) warn(d *Diagnostic) {
+	d.Severity = SeverityWarning
+	b.diags = append(b.diags, d)
+}
+
+// stmt 把一个 token 加入抽象语法树
+// 参数：
+//
+//	tok: 词法分析得到的 token
+//
+// 返回：
+//
+//	error: 语义错误，类型为 *Diagnostic；出错时抽象语法树保持不变
+func (b *builder) stmt(tok *Token) error {
+	ast := b.ast
+	switch tok.Kind {
+	case "BasisAssign":
+		args := tok.Args.(*BasisAssignArgs)
+		curBasis := args.Name
+
+		// 检查基名称是否符合规范（单个小写字母）
+		if !regexp.MustCompile(`^[a-z]$`).MatchString(curBasis) {
+			return tok.errorf(curBasis, "invalid basis name: %s, basis name should be a single lowercase letter", curBasis)
+		}
+
+		if _, ok := ast.Bases[curBasis]; ok {
+			return tok.errorf(curBasis, "basis redefined: %s", curBasis)
+		}
+		basis := &Basis{Name: curBasis, Pos: tok.Pos, tok: tok}
+
+		// 检查分量名称是否符合规范（基名称加上数字下标）
+		for _, vn := range args.Vecs {
+			key := vn // e.g. "b1"
+
+			// 检查分量名称是否以基名称开头，后跟数字
+			if !regexp.MustCompile(`^` + curBasis + `\d+$`).MatchString(key) {
+				return tok.errorf(VecText(key), "invalid vector name in basis %s: %s, vector name should be %s followed by number", curBasis, key, curBasis)
+			}
+
+			vec, ok := ast.Vecs[key]
 			if !ok {
-				fromBasis := args.DomainVec[0]
-				if _, exists := ast.Bases[fromBasis]; !exists {
-					return nil, fmt.Errorf("undefined basis: %s", fromBasis)
-				}
-				if _, exists := ast.Bases[toBasis]; !exists {
-					return nil, fmt.Errorf("undefined basis: %s", toBasis)
-				}
-				tr = &TransformRule{
-					Name:      args.Transform,
-					FromBasis: ast.Bases[fromBasis], // 默认
-					ToBasis:   ast.Bases[toBasis],
-					Map:       make(map[string][]LinearTerm),
-				}
-				ast.Transforms[args.Transform] = tr
-			}
-
-			// 2. 写入一行规则：T(b2) = ...
-			tr.Map[domainVec] = linearTerms
-
-		case "StmtEvalChangeBasis":
-			args := tok.Args.(*EvalChangeBasisArgs)
-
-			v, ok := ast.Vecs[args.Vec]
+				return b.undefined(tok, "vec:"+key, VecText(key), "basis uses undefined vector: %s", key)
+			}
+			basis.Vecs = append(basis.Vecs, vec)
+		}
+		// 所有向量都检查通过后才写入，失败的基不会留下半成品
+		ast.Bases[curBasis] = basis
+		delete(b.failed, "basis:"+curBasis)
+		for _, vec := range basis.Vecs {
+			// 设置向量的 Basis 字段为当前基
+			vec.Basis = basis
+		}
+
+	case "VectorCombo":
+		args := tok.Args.(*VecComboArgs)
+		terms, err := linearTerms(tok, args.RawTerms)
+		if err != nil {
+			return err
+		}
+		// 表达式中的向量必须已经定义，按定义时的对象绑定，之后的重定义不影响它
+		for i, t := range terms {
+			ref, ok := ast.Vecs[t.Vec]
 			if !ok {
-				return nil, fmt.Errorf("eval uses undefined vector: %s", args.Vec)
+				return b.undefined(tok, "vec:"+t.Vec, VecText(t.Vec), "vector expression uses undefined vector: %s", t.Vec)
+			}
+			terms[i].Ref = ref
+		}
+		if prev, ok := ast.Vecs[args.Name]; ok {
+			b.warn(tok.errorf(VecText(args.Name), "vector redefined: %s (previous definition at line %d)", args.Name, prev.Pos.Line))
+		}
+		ast.Vecs[args.Name] = &Vec{Name: args.Name, Expr: terms, Pos: tok.Pos}
+		delete(b.failed, "vec:"+args.Name)
+
+	case "VectorAssign":
+		args := tok.Args.(*VecAssignArgs)
+		if prev, ok := ast.Vecs[args.Name]; ok {
+			b.warn(tok.errorf(VecText(args.Name), "vector redefined: %s (previous definition at line %d)", args.Name, prev.Pos.Line))
+		}
+		v := &Vec{Name: args.Name, Comp: args.Comp, Exact: args.Exact, Pos: tok.Pos}
+		ast.Vecs[args.Name] = v
+		delete(b.failed, "vec:"+args.Name)
+
+	case "StmtTransformAssign":
+		args := tok.Args.(*TransformAssignArgs)
+		toBasis := args.RawTerms[0][2]
+		domainVec := args.DomainVec[0] + args.DomainVec[1]
+
+		for i, t := range args.RawTerms {
+			if t[2] != toBasis {
+				span := args.TermSpans[i]
+				return tok.errorAt(span[0], span[1], "to Basis error: inconsistent basis in linear combination")
+			}
+		}
+		terms, err := linearTerms(tok, args.RawTerms)
+		if err != nil {
+			return err
+		}
+		// 1. 查或建 TransformRule
+		tr, ok := ast.Transforms[args.Transform]
+		if !ok {
+			fromBasis := args.DomainVec[0]
+			if _, exists := ast.Bases[fromBasis]; !exists {
+				return b.undefined(tok, "basis:"+fromBasis, VecText(domainVec), "undefined basis: %s", fromBasis)
+			}
+			if _, exists := ast.Bases[toBasis]; !exists {
+				return b.undefined(tok, "basis:"+toBasis, args.RawTerms[0][0], "undefined basis: %s", toBasis)
 			}
+			tr = &TransformRule{
+				Name:      args.Transform,
+				FromBasis: ast.Bases[fromBasis], // 默认
+				ToBasis:   ast.Bases[toBasis],
+				Map:       make(map[string][]LinearTerm),
+				Pos:       tok.Pos,
+			}
+			ast.Transforms[args.Transform] = tr
+		}
+
+		// 规则把输入基中的一个向量映射为输出基中向量的线性组合，基之外的向量无法展开为矩阵
+		if tr.FromBasis.IndexOf(domainVec) < 0 {
+			return tok.errorf(VecText(domainVec), "transform rule for vector not in basis %s: %s", tr.FromBasis.Name, domainVec)
+		}
+		for i, t := range terms {
+			if tr.ToBasis.IndexOf(t.Vec) < 0 {
+				span := args.TermSpans[i]
+				return tok.errorAt(span[0], span[1], "vector %s is not in basis %s", t.Vec, tr.ToBasis.Name)
+			}
+		}
+
+		// 2. 写入一行规则：T(b2) = ...
+		ruleKey := args.Transform + "/" + domainVec
+		if prev, ok := b.rules[ruleKey]; ok {
+			b.warn(tok.errorf(VecText(domainVec), "transform rule redefined: %s(%s) (previous definition at line %d)", args.Transform, domainVec, prev.Line))
+		}
+		b.rules[ruleKey] = tok.Pos
+		tr.Map[domainVec] = terms
+
+	case "StmtEvalChangeBasis":
+		args := tok.Args.(*EvalChangeBasisArgs)
+
+		v, ok := ast.Vecs[args.Vec]
+		if !ok {
+			return b.undefined(tok, "vec:"+args.Vec, VecText(args.Vec), "eval uses undefined vector: %s", args.Vec)
+		}
+
+		basis, ok := ast.Bases[args.Basis]
+		if !ok {
+			return b.undefined(tok, "basis:"+args.Basis, "_"+args.Basis, "eval uses undefined basis: %s", args.Basis)
+		}
+
+		ast.Evals = append(ast.Evals, &EvalChangeBasis{
+			Vec:   v,
+			Basis: basis,
+			Line:  tok.Pos.Line,
+			Col:   tok.Pos.Col,
+		})
+
+	case "StmtEvalTransform":
+		args := tok.Args.(*EvalTransformArgs)
+		v, ok := ast.Vecs[args.VecName]
+		if !ok {
+			return b.undefined(tok, "vec:"+args.VecName, VecText(args.VecName), "eval uses undefined vector: %s", args.VecName)
+		}
+
+		t, ok := ast.Transforms[args.Transform]
+		if !ok {
+			return b.undefined(tok, "transform:"+args.Transform, args.Transform, "eval uses undefined transform: %s", args.Transform)
+		}
+		if b.failed["transform:"+args.Transform] {
+			// 变换的某条规则定义失败，结果不可信，静默跳过
+			return &Diagnostic{cascade: true}
+		}
 
-			basis, ok := ast.Bases[args.Basis]
+		ast.Evals = append(ast.Evals, &EvalTransform{
+			Transform: args.Transform,
+			Rule:      t,
+			Vec:       v,
+			Line:      tok.Pos.Line,
+			Col:       tok.Pos.Col,
+		})
+
+	case "StmtEvalTransformMatrix":
+		args := tok.Args.(*EvalTransformMatrixArgs)
+		t, ok := ast.Transforms[args.Transform]
+		if !ok {
+			return b.undefined(tok, "transform:"+args.Transform, args.Transform, "eval uses undefined transform: %s", args.Transform)
+		}
+		if b.failed["transform:"+args.Transform] {
+			return &Diagnostic{cascade: true}
+		}
+		bases := make([]*Basis, 2)
+		for i, name := range []string{args.From, args.To} {
+			basis, ok := ast.Bases[name]
 			if !ok {
-				return nil, fmt.Errorf("eval uses undefined basis: %s", args.Basis)
+				return b.undefined(tok, "basis:"+name, name, "eval uses undefined basis: %s", name)
 			}
+			bases[i] = basis
+		}
+		ast.Evals = append(ast.Evals, &EvalTransformMatrix{
+			Transform: args.Transform,
+			Rule:      t,
+			From:      bases[0],
+			To:        bases[1],
+			Line:      tok.Pos.Line,
+			Col:       tok.Pos.Col,
+		})
 
-			ast.Eval = &EvalChangeBasis{
-				Vec:   v,
-				Basis: basis,
+	case "StmtEvalTransition":
+		args := tok.Args.(*EvalTransitionArgs)
+		bases := make([]*Basis, 2)
+		for i, name := range []string{args.From, args.To} {
+			basis, ok := ast.Bases[name]
+			if !ok {
+				return b.undefined(tok, "basis:"+name, name, "eval uses undefined basis: %s", name)
 			}
-		case "StmtEvalTransform":
-			args := tok.Args.(*EvalTransformArgs)
-			v, ok := ast.Vecs[args.VecName]
+			bases[i] = basis
+		}
+		if len(args.Vecs) == 0 {
+			ast.Evals = append(ast.Evals, &EvalTransition{From: bases[0], To: bases[1], Line: tok.Pos.Line, Col: tok.Pos.Col})
+			break
+		}
+		// 作用在多个向量上时，每个向量一个计算请求，矩阵由计算器缓存复用
+		vecs := make([]*Vec, len(args.Vecs))
+		for i, name := range args.Vecs {
+			vec, ok := ast.Vecs[name]
 			if !ok {
-				return nil, fmt.Errorf("eval uses undefined vector: %s", args.VecName)
+				return b.undefined(tok, "vec:"+name, VecText(name), "eval uses undefined vector: %s", name)
 			}
+			vecs[i] = vec
+		}
+		for _, vec := range vecs {
+			ast.Evals = append(ast.Evals, &EvalTransition{From: bases[0], To: bases[1], Vec: vec, Line: tok.Pos.Line, Col: tok.Pos.Col})
+		}
 
-			t, ok := ast.Transforms[args.Transform]
+	case "StmtEvalInner", "StmtEvalAngle":
+		var u, v string
+		if args, ok := tok.Args.(*EvalInnerArgs); ok {
+			u, v = args.U, args.V
+		} else {
+			args := tok.Args.(*EvalAngleArgs)
+			u, v = args.U, args.V
+		}
+		vecs := make([]*Vec, 2)
+		for i, name := range []string{u, v} {
+			vec, ok := ast.Vecs[name]
 			if !ok {
-				return nil, fmt.Errorf("eval uses undefined transform: %s", args.Transform)
+				return b.undefined(tok, "vec:"+name, VecText(name), "eval uses undefined vector: %s", name)
 			}
+			vecs[i] = vec
+		}
+		if tok.Kind == "StmtEvalInner" {
+			ast.Evals = append(ast.Evals, &EvalInner{U: vecs[0], V: vecs[1], Line: tok.Pos.Line, Col: tok.Pos.Col})
+		} else {
+			ast.Evals = append(ast.Evals, &EvalAngle{U: vecs[0], V: vecs[1], Line: tok.Pos.Line, Col: tok.Pos.Col})
+		}
+
+	case "StmtEvalNorm":
+		args := tok.Args.(*EvalNormArgs)
+		v, ok := ast.Vecs[args.VecName]
+		if !ok {
+			return b.undefined(tok, "vec:"+args.VecName, VecText(args.VecName), "eval uses undefined vector: %s", args.VecName)
+		}
+		ast.Evals = append(ast.Evals, &EvalNorm{Vec: v, Line: tok.Pos.Line, Col: tok.Pos.Col})
 
-			ast.Eval = &EvalTransform{
-				Transform: args.Transform,
-				Rule:      t,
-				Vec:       v,
+	case "StmtEvalCompose":
+		args := tok.Args.(*EvalComposeArgs)
+		v, ok := ast.Vecs[args.VecName]
+		if !ok {
+			return b.undefined(tok, "vec:"+args.VecName, VecText(args.VecName), "eval uses undefined vector: %s", args.VecName)
+		}
+
+		rules := make([]*TransformRule, len(args.Transforms))
+		for i, name := range args.Transforms {
+			t, ok := ast.Transforms[name]
+			if !ok {
+				return b.undefined(tok, "transform:"+name, name, "eval uses undefined transform: %s", name)
+			}
+			if b.failed["transform:"+name] {
+				return &Diagnostic{cascade: true}
 			}
+			rules[i] = t
 		}
+
+		ast.Evals = append(ast.Evals, &EvalCompose{
+			Transforms: args.Transforms,
+			Rules:      rules,
+			Vec:        v,
+			Line:       tok.Pos.Line,
+			Col:        tok.Pos.Col,
+		})
+	}
+	return nil
+}
+
+// linearTerms 把词法分析得到的线性组合各项转换为 LinearTerm
+// 参数：
+//
+//	tok: 所在的 token，用于诊断信息
+//	raw: 每一项的子匹配：[全文, 系数, 向量字母, 下标]
+//
+// 返回：
+//
+//	[]LinearTerm: 线性组合
+//	error: 系数不合法时返回错误
+func linearTerms(tok *Token, raw [][]string) ([]LinearTerm, error) {
+	terms := make([]LinearTerm, 0, len(raw))
+	for _, t := range raw {
+		r, err := parseCoeff(t[1])
+		if err != nil {
+			return nil, tok.errorf(strings.TrimSpace(t[1]), "invalid coefficient: %s", strings.TrimSpace(t[1]))
+		}
+		coeff, _ := r.Float64()
+		terms = append(terms, LinearTerm{Coeff: coeff, Exact: r, Vec: t[2] + t[3]})
+	}
+	return terms, nil
+}
+
+// VecText 把向量名还原为它在源代码中的写法，用于在诊断信息中定位
+// 参数：
+//
+//	name: 向量名，如 "b1"、"v"
+//
+// 返回：
+//
+//	string: 源代码写法，如 `\vec{b}_1`、`\vec{v}`
+func VecText(name string) string {
+	i := strings.IndexFunc(name, unicode.IsDigit)
+	if i <= 0 {
+		return `\vec{` + name + `}`
 	}
-	return ast, nil
+	return `\vec{` + name[:i] + `}_` + name[i:]
 }
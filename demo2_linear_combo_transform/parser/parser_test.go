@@ -0,0 +1,41 @@
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+const ruleBases = `\vec{b}_1 = \begin{pmatrix}1\\0\end{pmatrix}
+\vec{b}_2 = \begin{pmatrix}0\\1\end{pmatrix}
+b = \{\vec{b}_1, \vec{b}_2\}
+`
+
+// 变换规则中不在基中的向量报告为诊断信息，不能让后面的计算 panic
+func TestTransformRuleOutsideBasis(t *testing.T) {
+	tests := []struct {
+		rule string
+		msg  string
+		col  int
+	}{
+		{`T(\vec{b}_1) = \vec{b}_1 + 2\vec{b}_3`, "vector b3 is not in basis b", 29},
+		{`T(\vec{b}_3) = \vec{b}_2`, "transform rule for vector not in basis b: b3", 3},
+	}
+	for _, tt := range tests {
+		ast, diags := ParseAll("", strings.NewReader(ruleBases+tt.rule+"\n"))
+		if len(diags) != 1 {
+			t.Errorf("%s: got %d diagnostics, want 1: %v", tt.rule, len(diags), diags)
+			continue
+		}
+		d := diags[0]
+		if d.Msg != tt.msg || d.Pos.Line != 4 || d.Pos.Col != tt.col {
+			t.Errorf("%s: got %d:%d: %s, want 4:%d: %s", tt.rule, d.Pos.Line, d.Pos.Col, d.Msg, tt.col, tt.msg)
+		}
+		if tr := ast.Transforms["T"]; tr != nil {
+			for v := range tr.Map {
+				if tr.FromBasis.IndexOf(v) < 0 {
+					t.Errorf("%s: rule for %s kept in the AST", tt.rule, v)
+				}
+			}
+		}
+	}
+}
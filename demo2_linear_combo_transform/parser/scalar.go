@@ -0,0 +1,90 @@
+package parser
+
+import (
+	"fmt"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+// numberPattern 匹配无符号的整数或小数，如 3、0.5、.25
+const numberPattern = `(?:\d+(?:\.\d*)?|\.\d+)`
+
+// scalarPattern 匹配一个无符号的标量字面量，可嵌入到其它正则中（不含捕获组）
+// 支持：
+//
+//	整数与小数：3、0.5
+//	斜杠分数：1/3
+//	LaTeX 分数：\frac{1}{3}、\tfrac{1}{3}、\dfrac{1}{3}、\frac12
+const scalarPattern = numberPattern + `(?:\s*/\s*` + numberPattern + `)?` +
+	`|\\[dt]?frac\s*(?:\{[^{}]*\}|\d)\s*(?:\{[^{}]*\}|\d)`
+
+var (
+	slashScalarRe = regexp.MustCompile(`^(` + numberPattern + `)(?:\s*/\s*(` + numberPattern + `))?$`)
+	fracScalarRe  = regexp.MustCompile(`^\\[dt]?frac\s*(?:\{\s*([+-]?\s*` + numberPattern + `)\s*\}|(\d))\s*(?:\{\s*([+-]?\s*` + numberPattern + `)\s*\}|(\d))$`)
+)
+
+// parseScalar 解析一个标量字面量，返回精确的有理数值
+// 参数：
+//
+//	s: 字面量文本，可带一个前导正负号，如 -0.5、+1/3、-\frac{1}{3}
+//
+// 返回：
+//
+//	*big.Rat: 字面量的有理数值
+//	error: 字面量不合法或分母为零时返回错误
+func parseScalar(s string) (*big.Rat, error) {
+	text := strings.TrimSpace(s)
+	neg := false
+	if strings.HasPrefix(text, "-") || strings.HasPrefix(text, "+") {
+		neg = text[0] == '-'
+		text = strings.TrimSpace(text[1:])
+	}
+
+	var num, den string
+	if m := slashScalarRe.FindStringSubmatch(text); m != nil {
+		num, den = m[1], m[2]
+	} else if m := fracScalarRe.FindStringSubmatch(text); m != nil {
+		num, den = m[1]+m[2], m[3]+m[4]
+	} else {
+		return nil, fmt.Errorf("invalid scalar literal: %s", s)
+	}
+
+	r, ok := new(big.Rat).SetString(strings.ReplaceAll(num, " ", ""))
+	if !ok {
+		return nil, fmt.Errorf("invalid scalar literal: %s", s)
+	}
+	if den != "" {
+		d, ok := new(big.Rat).SetString(strings.ReplaceAll(den, " ", ""))
+		if !ok {
+			return nil, fmt.Errorf("invalid scalar literal: %s", s)
+		}
+		if d.Sign() == 0 {
+			return nil, fmt.Errorf("division by zero in scalar literal: %s", s)
+		}
+		r.Quo(r, d)
+	}
+	if neg {
+		r.Neg(r)
+	}
+	return r, nil
+}
+
+// parseCoeff 解析线性组合中某一项的系数
+// 参数：
+//
+//	s: 系数文本，可能为空或只有符号，如 ""、"+"、"-"、"- 2"、"+\frac{1}{2}"
+//
+// 返回：
+//
+//	*big.Rat: 系数值，省略数字时为 ±1
+//	error: 系数不合法时返回错误
+func parseCoeff(s string) (*big.Rat, error) {
+	switch strings.ReplaceAll(s, " ", "") {
+	case "", "+":
+		return big.NewRat(1, 1), nil
+	case "-":
+		return big.NewRat(-1, 1), nil
+	}
+	return parseScalar(s)
+}
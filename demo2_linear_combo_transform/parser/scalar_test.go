@@ -0,0 +1,126 @@
+package parser
+
+import (
+	"math/big"
+	"regexp"
+	"testing"
+)
+
+func TestParseScalar(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string // 约分后的分数，为空时期望错误
+	}{
+		{"3", "3"},
+		{"0", "0"},
+		{"0.5", "1/2"},
+		{".25", "1/4"},
+		{"2.", "2"},
+		{"-0.5", "-1/2"},
+		{"+3", "3"},
+		{"- 3", "-3"},
+		{"1/3", "1/3"},
+		{"-1/3", "-1/3"},
+		{"2 / 4", "1/2"},
+		{"0.5/2", "1/4"},
+		{`\frac{1}{3}`, "1/3"},
+		{`\frac12`, "1/2"},
+		{`\frac1{3}`, "1/3"},
+		{`\tfrac{2}{4}`, "1/2"},
+		{`\dfrac{3}{2}`, "3/2"},
+		{`-\frac{1}{3}`, "-1/3"},
+		{`\frac{-1}{3}`, "-1/3"},
+		{`\frac{.5}{2}`, "1/4"},
+		{"  7  ", "7"},
+
+		{"", ""},
+		{"-", ""},
+		{"abc", ""},
+		{"1/0", ""},
+		{"1/0.0", ""},
+		{`\frac{1}{0}`, ""},
+		{`\frac10`, ""},
+		{"1/2/3", ""},
+		{"1e3", ""},
+		{"--1", ""},
+		{`\frac{x}{2}`, ""},
+		{`\frac{1}`, ""},
+	}
+	for _, tt := range tests {
+		got, err := parseScalar(tt.in)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("parseScalar(%q) = %s, want error", tt.in, got.RatString())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseScalar(%q) error: %v", tt.in, err)
+			continue
+		}
+		if got.RatString() != tt.want {
+			t.Errorf("parseScalar(%q) = %s, want %s", tt.in, got.RatString(), tt.want)
+		}
+	}
+}
+
+func TestParseCoeff(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"", "1"},
+		{"+", "1"},
+		{" + ", "1"},
+		{"-", "-1"},
+		{" - ", "-1"},
+		{"2", "2"},
+		{"- 2", "-2"},
+		{"+0.5", "1/2"},
+		{`+\frac{1}{2}`, "1/2"},
+		{`-\frac12`, "-1/2"},
+		{"-1/3", "-1/3"},
+
+		{"1/0", ""},
+		{"+-", ""},
+		{"x", ""},
+	}
+	for _, tt := range tests {
+		got, err := parseCoeff(tt.in)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("parseCoeff(%q) = %s, want error", tt.in, got.RatString())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCoeff(%q) error: %v", tt.in, err)
+			continue
+		}
+		if got.Cmp(mustRat(t, tt.want)) != 0 {
+			t.Errorf("parseCoeff(%q) = %s, want %s", tt.in, got.RatString(), tt.want)
+		}
+	}
+}
+
+// 嵌入到其它正则中的 scalarPattern 必须能匹配 parseScalar 接受的每一种写法
+func TestScalarPatternMatchesLiterals(t *testing.T) {
+	re := regexp.MustCompile(`^(?:` + scalarPattern + `)$`)
+	for _, s := range []string{"3", "0.5", ".25", "1/3", "2 / 4", `\frac{1}{3}`, `\frac12`, `\tfrac{2}{4}`, `\dfrac{3}{2}`} {
+		if !re.MatchString(s) {
+			t.Errorf("scalarPattern does not match %q", s)
+		}
+		if _, err := parseScalar(s); err != nil {
+			t.Errorf("parseScalar(%q) error: %v", s, err)
+		}
+	}
+}
+
+func mustRat(t *testing.T, s string) *big.Rat {
+	t.Helper()
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		t.Fatalf("bad test value %q", s)
+	}
+	return r
+}
@@ -0,0 +1,123 @@
+package parser
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// Session 是交互式会话：每次输入一条语句（或一个完整的显示公式块），在同一棵抽象语法树上增量构建
+// 与 ParseAll 一样以容错模式解析，出错的输入不改变抽象语法树
+type Session struct {
+	file string // 诊断信息中的文件名，如 <repl>
+	line int    // 已输入的行数，诊断信息中的行号在整个会话中累计
+	b    *builder
+}
+
+// NewSession 创建一个空的会话
+// 参数：
+//
+//	file: 诊断信息中显示的文件名
+//
+// 返回：
+//
+//	*Session: 会话
+func NewSession(file string) *Session {
+	return &Session{file: file, b: newBuilder()}
+}
+
+// AST 返回会话当前的抽象语法树，包含到目前为止的全部定义和计算请求
+func (s *Session) AST() *AST {
+	return s.b.ast
+}
+
+// Reset 清空会话中的全部定义和计算请求，行号继续累计
+func (s *Session) Reset() {
+	s.b = newBuilder()
+}
+
+// Feed 解析一段输入并加入抽象语法树
+// 参数：
+//
+//	text: 输入，可以有多行；显示公式块必须完整，见 Incomplete
+//
+// 返回：
+//
+//	[]EvalStmt: 这段输入新增的计算请求
+//	Diagnostics: 这段输入的错误和警告
+func (s *Session) Feed(text string) ([]EvalStmt, Diagnostics) {
+	// 交互式输入本身就是公式，没有写 \[ ... \] 时也按显示公式块切分，
+	// 使一行中以 \quad 分隔的多条语句各自生效；定界符各占一行，不计入行号
+	wrapped := !displayStart(text)
+	if wrapped {
+		text = "\\[\n" + text + "\n\\]"
+	}
+	l := NewLexer(strings.NewReader(text))
+	l.file = s.file
+	l.line = s.line
+	if wrapped {
+		l.line--
+	}
+	evals, diags := s.consume(l)
+	s.line = l.line
+	if wrapped {
+		s.line--
+	}
+	return evals, diags
+}
+
+// displayStart 报告输入的第一个非空行是否以显示公式块的开始定界符开头
+func displayStart(text string) bool {
+	for _, line := range strings.Split(text, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			_, _, ok := displayOpen(line)
+			return ok
+		}
+	}
+	return false
+}
+
+// Load 把一个文件中的全部语句加入抽象语法树，诊断信息使用文件自己的文件名和行号
+// 参数：
+//
+//	filename: 文件名
+//	r: 文件内容
+//
+// 返回：
+//
+//	[]EvalStmt: 文件中的计算请求
+//	Diagnostics: 文件中的错误和警告
+func (s *Session) Load(filename string, r io.Reader) ([]EvalStmt, Diagnostics) {
+	l := NewLexer(r)
+	l.file = filename
+	return s.consume(l)
+}
+
+// consume 读取词法分析器中的全部语句，返回新增的计算请求和诊断信息
+func (s *Session) consume(l *Lexer) ([]EvalStmt, Diagnostics) {
+	evals, diags := len(s.b.ast.Evals), len(s.b.diags)
+	s.b.consume(l, nil)
+	return s.b.ast.Evals[evals:], s.b.diags[diags:]
+}
+
+// Incomplete 报告输入中是否有尚未结束的 \[ ... \] 或 $$ ... $$ 显示公式块，
+// 交互式输入据此决定是否继续读取下一行
+func Incomplete(text string) bool {
+	sc := bufio.NewScanner(strings.NewReader(text))
+	close := ""
+	for sc.Scan() {
+		line := sc.Text()
+		if close == "" {
+			open, c, ok := displayOpen(strings.TrimSpace(line))
+			if !ok {
+				continue
+			}
+			close = c
+			line = strings.TrimSpace(line)[len(open):]
+		}
+		if strings.Contains(line, close) {
+			close = ""
+		}
+	}
+	return close != ""
+}
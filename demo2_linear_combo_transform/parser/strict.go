@@ -0,0 +1,59 @@
+package parser
+
+import "regexp"
+
+// 严格模式下只有以下两处的内容是语句，笔记中的其余内容都是正文：
+//
+//	#+begin_src mathlang        源代码块中的每一行，以及其中的显示公式块
+//	...
+//	#+end_src
+//
+//	#+mathlang                  紧接在指令之后（中间只能有空行）的一个显示公式块
+//	\[ ... \]
+var (
+	srcBeginRe  = regexp.MustCompile(`(?i)^#\+begin_src\s+mathlang(?:\s|$)`)
+	srcEndRe    = regexp.MustCompile(`(?i)^#\+end_src$`)
+	directiveRe = regexp.MustCompile(`(?i)^#\+mathlang$`)
+)
+
+// fenceState 记录严格模式下词法分析器所在的位置
+type fenceState struct {
+	src       *Token // 当前所在的 mathlang 源代码块的开始行，不在块中时为 nil
+	directive bool   // 上一个非空行是 #+mathlang 指令
+}
+
+// prose 在严格模式下处理一行，报告它是否是正文
+// 源代码块之外的标题和属性抽屉仍然更新当前所在的标题
+// 参数：
+//
+//	raw: 物理行
+//	line: 去除首尾空白的行
+//
+// 返回：
+//
+//	bool: 该行是正文、源代码块的定界符或指令，不是语句
+func (l *Lexer) prose(raw, line string) bool {
+	f := &l.fence
+	if f.src != nil {
+		if srcEndRe.MatchString(line) {
+			f.src = nil
+			return true
+		}
+		return false
+	}
+	marked := f.directive
+	if line != "" {
+		f.directive = false
+	}
+	switch {
+	case l.org.line(line, l.line):
+	case srcBeginRe.MatchString(line):
+		f.src = &Token{Pos: Pos{File: l.file, Line: l.line, Col: 1}, Text: raw, Heading: l.org.current}
+	case directiveRe.MatchString(line):
+		f.directive = true
+	case marked:
+		_, _, ok := displayOpen(line)
+		return !ok
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestStrictMode(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		vecs  string // 定义的向量，按名称排序，用空格分隔
+		evals int
+		diags []string // 严格模式下的诊断信息
+		loose int      // 默认模式下的诊断信息条数
+	}{
+		{
+			"prose with braces",
+			`The set \{\vec{b}_1, \vec{b}_2\} spans {R^2}, so \vec{v} = {x} is fine.
+Write [\vec{v}]_b for the coordinates of {v}.
+`,
+			"", 0, nil, 2,
+		},
+		{
+			"src block",
+			`* Note
+#+begin_src mathlang :results none
+\vec{v} = \begin{pmatrix}1\\2\end{pmatrix}
+\[ \vec{w} = \begin{pmatrix}3\\4\end{pmatrix} \quad
+   \langle \vec{v}, \vec{w} \rangle \leftarrow \text{eval} \]
+  \|\vec{v}\| \leftarrow \text{eval}
+#+END_SRC
+\|\vec{w}\| \leftarrow \text{eval}
+`,
+			"v w", 2, nil, 0,
+		},
+		{
+			"src block of another language",
+			`#+begin_src python
+\vec{v} = \begin{pmatrix}1\\q\end{pmatrix}
+#+end_src
+`,
+			"", 0, nil, 1,
+		},
+		{
+			"directive followed by a display",
+			`#+mathlang
+
+\[
+\vec{u} = \begin{pmatrix}1\\0\end{pmatrix}
+\]
+\[ \vec{x} = \begin{pmatrix}0\\1\end{pmatrix} \]
+`,
+			"u", 0, nil, 0,
+		},
+		{
+			"directive followed by prose",
+			`#+MATHLANG
+Some prose first.
+\[ \vec{u} = \begin{pmatrix}1\\0\end{pmatrix} \]
+`,
+			"", 0, nil, 0,
+		},
+		{
+			"unmarked display",
+			`\[ \vec{u} = \begin{pmatrix}1\\q\end{pmatrix} \]
+$$ \|\vec{u}\| \leftarrow \text{eval} $$
+`,
+			"", 0, nil, 1,
+		},
+		{
+			"unterminated src block",
+			`#+begin_src mathlang
+\vec{v} = \begin{pmatrix}1\\2\end{pmatrix}
+`,
+			"v", 0, []string{"1:1: unterminated source block: missing #+end_src"}, 0,
+		},
+	}
+	for _, tt := range tests {
+		ast, diags := ParseSelected("", strings.NewReader(tt.src), &Selection{Strict: true})
+		var names []string
+		for name := range ast.Vecs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if got := strings.Join(names, " "); got != tt.vecs {
+			t.Errorf("%s: vectors %q, want %q", tt.name, got, tt.vecs)
+		}
+		if len(ast.Evals) != tt.evals {
+			t.Errorf("%s: %d evals, want %d", tt.name, len(ast.Evals), tt.evals)
+		}
+		var got []string
+		for _, d := range diags {
+			got = append(got, strings.TrimPrefix(d.Error(), "<stdin>:"))
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.diags, "\n") {
+			t.Errorf("%s: diagnostics %q, want %q", tt.name, got, tt.diags)
+		}
+		// 同一篇笔记在默认模式下按行识别语句
+		if _, loose := ParseAll("", strings.NewReader(tt.src)); len(loose) != tt.loose {
+			t.Errorf("%s: %d diagnostics without strict mode, want %d: %v", tt.name, len(loose), tt.loose, loose)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/btsyang/mathlang/calculator"
+	"github.com/btsyang/mathlang/output"
+	"github.com/btsyang/mathlang/parser"
+)
+
+// replHelp 是 :help 输出的说明
+const replHelp = `Enter one statement per line; a \[ or $$ block continues until it is closed.
+Every eval is computed as soon as it is entered.
+
+  :vars          list defined vectors, bases and transforms
+  :show NAME     show the definition of a vector, basis or transform
+  :load FILE     read a note into the session and run its evals
+  :reset         forget all definitions
+  :help          show this help
+  :quit          leave (or Ctrl-D)`
+
+// repl 是交互式解释器的状态
+type repl struct {
+	session *parser.Session
+	opts    calculator.Options
+	matrix  string    // 矩阵结果的输出形式，同 -matrix
+	out     io.Writer // 结果和命令的输出，通常是标准输出
+	errs    io.Writer // 诊断信息和错误，通常是标准错误
+	pending []string  // 尚未结束的显示公式块中已输入的行
+}
+
+// runREPL 运行交互式解释器：mathlang repl [-exact] [-matrix table|latex] [-explain] [-history file]
+// 每次读取一条语句（或一个完整的显示公式块），增量地加入抽象语法树，并立即输出其中计算请求的结果
+// 参数：
+//
+//	args: repl 之后的命令行参数
+func runREPL(args []string) {
+	fs := flag.NewFlagSet("repl", flag.ExitOnError)
+	exact := fs.Bool("exact", false, "使用 big.Rat 做精确的有理数运算，结果输出为约分后的分数")
+	matrix := fs.String("matrix", "table", "矩阵结果的输出形式：table（按列对齐的文本表格）或 latex（pmatrix）")
+	explain := fs.Bool("explain", false, "在每个结果之后输出求解过程")
+	histFile := ""
+	if home, err := os.UserHomeDir(); err == nil {
+		histFile = filepath.Join(home, ".mathlang_history")
+	}
+	history := fs.String("history", histFile, "历史记录文件，为空时不保存历史记录")
+	fs.Parse(args)
+
+	r := &repl{
+		session: parser.NewSession("<repl>"),
+		opts:    calculator.Options{Exact: *exact, Explain: *explain},
+		matrix:  *matrix,
+		out:     os.Stdout,
+		errs:    os.Stderr,
+	}
+	ed := newLineEditor(os.Stdin, os.Stdout, *history)
+	if ed.interactive {
+		fmt.Println("mathlang repl: :help for commands, Ctrl-D to quit")
+	}
+
+	for {
+		prompt := ">> "
+		if len(r.pending) > 0 {
+			prompt = ".. "
+		}
+		line, err := ed.readLine(prompt)
+		if err == errInterrupt {
+			r.pending = nil
+			continue
+		}
+		if err != nil {
+			return
+		}
+		if r.input(line) {
+			return
+		}
+	}
+}
+
+// input 处理输入的一行：显示公式块之外以 : 开头的是命令，其余的行加入语句，语句完整后计算
+// 参数：
+//
+//	line: 输入的一行
+//
+// 返回：
+//
+//	bool: 是否退出解释器
+func (r *repl) input(line string) bool {
+	if cmd := strings.TrimSpace(line); len(r.pending) == 0 && strings.HasPrefix(cmd, ":") {
+		return r.command(cmd)
+	}
+	r.pending = append(r.pending, line)
+	text := strings.Join(r.pending, "\n")
+	if parser.Incomplete(text) {
+		return false
+	}
+	r.pending = nil
+	r.run("<repl>", func() ([]parser.EvalStmt, parser.Diagnostics) { return r.session.Feed(text) })
+	return false
+}
+
+// run 把一段输入加入会话，检查新定义的基，并计算新增的计算请求
+// 参数：
+//
+//	srcName: 计算错误中显示的文件名
+//	feed: 把输入加入会话，返回新增的计算请求和诊断信息
+func (r *repl) run(srcName string, feed func() ([]parser.EvalStmt, parser.Diagnostics)) {
+	before := maps.Clone(r.session.AST().Bases)
+	evals, diags := feed()
+
+	// 只检查和计算这次输入新增的部分，之前的定义照常可以引用
+	view := *r.session.AST()
+	view.Bases = make(map[string]*parser.Basis)
+	for name, b := range r.session.AST().Bases {
+		if before[name] != b {
+			view.Bases[name] = b
+		}
+	}
+	view.Evals = evals
+	diags = append(diags, calculator.Check(&view, r.opts)...)
+	diags.Sort()
+	for _, d := range diags {
+		fmt.Fprint(r.errs, d.Format())
+	}
+	results, _ := calculator.Calculate(&view, r.opts)
+	printText(r.out, r.errs, results, srcName, r.matrix)
+}
+
+// command 执行以 : 开头的命令
+// 参数：
+//
+//	line: 命令行，如 :show b
+//
+// 返回：
+//
+//	bool: 是否退出解释器
+func (r *repl) command(line string) bool {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch name {
+	case ":quit", ":q":
+		return true
+	case ":help", ":h":
+		fmt.Fprintln(r.out, replHelp)
+	case ":vars":
+		r.vars(r.out)
+	case ":show":
+		if arg == "" {
+			fmt.Fprintln(r.errs, "usage: :show NAME")
+			break
+		}
+		lines := output.Definition(r.session.AST(), arg)
+		if len(lines) == 0 {
+			fmt.Fprintf(r.errs, "undefined: %s\n", arg)
+		}
+		for _, l := range lines {
+			fmt.Fprintln(r.out, l)
+		}
+	case ":reset":
+		r.session.Reset()
+		fmt.Fprintln(r.out, "all definitions cleared")
+	case ":load":
+		if arg == "" {
+			fmt.Fprintln(r.errs, "usage: :load FILE")
+			break
+		}
+		f, err := os.Open(arg)
+		if err != nil {
+			fmt.Fprintln(r.errs, err)
+			break
+		}
+		defer f.Close()
+		r.run(arg, func() ([]parser.EvalStmt, parser.Diagnostics) { return r.session.Load(arg, f) })
+	default:
+		fmt.Fprintf(r.errs, "unknown command: %s (try :help)\n", name)
+	}
+	return false
+}
+
+// vars 按名称排序列出会话中定义的向量、基和变换
+func (r *repl) vars(w io.Writer) {
+	ast := r.session.AST()
+	groups := []struct {
+		title string
+		names []string
+	}{
+		{"vectors", sortedKeys(ast.Vecs)},
+		{"bases", sortedKeys(ast.Bases)},
+		{"transforms", sortedKeys(ast.Transforms)},
+	}
+	empty := true
+	for _, g := range groups {
+		if len(g.names) == 0 {
+			continue
+		}
+		empty = false
+		fmt.Fprintf(w, "%s: %s\n", g.title, strings.Join(g.names, " "))
+	}
+	if empty {
+		fmt.Fprintln(w, "no definitions")
+	}
+}
+
+// sortedKeys 返回映射中按字典序排列的键
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/btsyang/mathlang/calculator"
+	"github.com/btsyang/mathlang/parser"
+)
+
+// testREPL 返回一个不连接终端的解释器，输出写入返回的两个缓冲区
+func testREPL() (*repl, *bytes.Buffer, *bytes.Buffer) {
+	var out, errs bytes.Buffer
+	r := &repl{
+		session: parser.NewSession("<repl>"),
+		opts:    calculator.Options{Exact: true},
+		matrix:  "table",
+		out:     &out,
+		errs:    &errs,
+	}
+	return r, &out, &errs
+}
+
+// replStep 是输入的一行及其输出，errs 是标准错误中应包含的片段，为空时标准错误应为空
+type replStep struct {
+	in   string
+	out  string
+	errs string
+}
+
+// runSteps 依次输入每一行并检查输出，除最后一行外都不应退出解释器
+func runSteps(t *testing.T, r *repl, out, errs *bytes.Buffer, steps []replStep) {
+	t.Helper()
+	for i, s := range steps {
+		out.Reset()
+		errs.Reset()
+		if quit := r.input(s.in); quit != (i == len(steps)-1 && strings.HasPrefix(s.in, ":q")) {
+			t.Errorf("%q: quit = %v", s.in, quit)
+		}
+		if out.String() != s.out {
+			t.Errorf("%q: stdout %q, want %q", s.in, out, s.out)
+		}
+		if s.errs == "" && errs.Len() > 0 || !strings.Contains(errs.String(), s.errs) {
+			t.Errorf("%q: stderr %q, want %q", s.in, errs, s.errs)
+		}
+	}
+}
+
+func TestREPLEvaluatesLines(t *testing.T) {
+	r, out, errs := testREPL()
+	runSteps(t, r, out, errs, []replStep{
+		{`\vec{b}_1 = \begin{pmatrix}1\\0\end{pmatrix}`, "", ""},
+		{`\vec{b}_2 = \begin{pmatrix}0\\1\end{pmatrix}`, "", ""},
+		{`b = \{\vec{b}_1, \vec{b}_2\}`, "", ""},
+		{`\vec{v} = 2\vec{b}_1 + \vec{b}_2`, "", ""},
+		{`[\vec{v}]_b \leftarrow \text{eval}`, "[\\vec{v}]_b = (2 1)\n", ""},
+		{`T(\vec{b}_1) = \vec{b}_2`, "", ""},
+		{`T(\vec{b}_2) = \vec{b}_1`, "", ""},
+		// 显示公式块在闭合后整体计算，块中以 : 开头的行不是命令，之前的计算请求不再输出
+		{`\[`, "", ""},
+		{`T(\vec{v}) \leftarrow \text{eval} \quad`, "", ""},
+		{`:vars`, "", ""},
+		{`\|\vec{v}\| \leftarrow \text{eval}`, "", ""},
+		{`\]`, "T(\\vec{v}) = (1 2)\n\\|\\vec{v}\\| = √5\n", ""},
+		{`[\vec{x}]_b \leftarrow \text{eval}`, "", "<repl>:13:2: error: eval uses undefined vector: x"},
+		{`\vec{y} = \begin{pmatrix}1\\q\end{pmatrix}`, "", `invalid component value: "q"`},
+		// 出错的行不影响之后的输入
+		{`\vec{y} = \begin{pmatrix}1\\2\end{pmatrix}`, "", ""},
+		{`\langle \vec{v}, \vec{y} \rangle \leftarrow \text{eval}`, "\\langle \\vec{v}, \\vec{y} \\rangle = 4\n", ""},
+		{`:q`, "", ""},
+	})
+}
+
+func TestREPLCommands(t *testing.T) {
+	note := filepath.Join(t.TempDir(), "note.org")
+	src := `* Bases
+\vec{b}_1 = \begin{pmatrix}1\\1\end{pmatrix}
+\vec{b}_2 = \begin{pmatrix}1\\-1\end{pmatrix}
+b = \{\vec{b}_1, \vec{b}_2\}
+\vec{v} = \begin{pmatrix}2\\0\end{pmatrix}
+[\vec{v}]_b \leftarrow \text{eval}
+[\vec{u}]_b \leftarrow \text{eval}
+`
+	if err := os.WriteFile(note, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r, out, errs := testREPL()
+	runSteps(t, r, out, errs, []replStep{
+		{`:vars`, "no definitions\n", ""},
+		{`:load`, "", "usage: :load FILE"},
+		{`:load ` + note + `.missing`, "", "no such file or directory"},
+		{`:load ` + note, "[\\vec{v}]_b = (1 1)\n", note + ":7:2: error: eval uses undefined vector: u"},
+		{`T(\vec{b}_1) = \vec{b}_1`, "", ""},
+		{`T(\vec{b}_2) = -\vec{b}_2`, "", ""},
+		{`  :vars  `, "vectors: b1 b2 v\nbases: b\ntransforms: T\n", ""},
+		{`:show b`, "b = {b1, b2}\n", ""},
+		{`:show T`, "T: b -> b\n  T(b1) = b1\n  T(b2) = -b2\n", ""},
+		{`:show`, "", "usage: :show NAME"},
+		{`:show w`, "", "undefined: w"},
+		{`:h`, replHelp + "\n", ""},
+		{`:frobnicate`, "", "unknown command: :frobnicate (try :help)"},
+		{`:reset`, "all definitions cleared\n", ""},
+		{`:vars`, "no definitions\n", ""},
+		{`[\vec{v}]_b \leftarrow \text{eval}`, "", "eval uses undefined vector: v"},
+		{`:quit`, "", ""},
+	})
+}
@@ -0,0 +1,11 @@
+//go:build linux
+
+package main
+
+import "syscall"
+
+// 读取和设置终端属性的 ioctl 请求
+const (
+	ioctlGetTermios = syscall.TCGETS
+	ioctlSetTermios = syscall.TCSETS
+)